@@ -20,6 +20,7 @@ var fileEventToPoll = [3]uint32{0, unix.POLLIN, unix.POLLOUT}
 
 type FileProcFn func(extra interface{}) // 文件处理回调
 type TimeProcFn func(extra interface{}) // 时间处理回调
+type BeforeSleepProcFn func()           // 每轮事件循环等待前的回调
 
 // FileEvent 文件事件
 type FileEvent struct {
@@ -52,6 +53,7 @@ type EventLoop struct {
 	timeEvents      *TimeEvent
 	fileEventFd     int // server epoll fd
 	timeEventNextId int
+	beforeSleep     BeforeSleepProcFn
 	stop            bool
 }
 
@@ -128,7 +130,8 @@ func (loop *EventLoop) DelEvent(fd int, eventType FileEventType) (err error) {
 	return nil
 }
 
-func (loop *EventLoop) AddTimeEvent(interval int64, eventType TimeEventType, fn TimeProcFn, extra interface{}) {
+// AddTimeEvent 返回时间事件id，可用于 DelTimeEvent
+func (loop *EventLoop) AddTimeEvent(interval int64, eventType TimeEventType, fn TimeProcFn, extra interface{}) int {
 	loop.timeEventNextId++
 	id := loop.timeEventNextId
 
@@ -145,7 +148,7 @@ func (loop *EventLoop) AddTimeEvent(interval int64, eventType TimeEventType, fn
 		next:          loop.timeEvents,
 		extra:         extra,
 	}
-	return
+	return id
 }
 
 func (loop *EventLoop) DelTimeEvent(id int) {
@@ -154,7 +157,7 @@ func (loop *EventLoop) DelTimeEvent(id int) {
 	for cur != nil {
 		if cur.id == id {
 			if pre == nil {
-				loop.timeEvents = cur
+				loop.timeEvents = cur.next
 			} else {
 				pre.next = cur.next
 			}
@@ -224,8 +227,15 @@ retry:
 	return fes, tes, nil
 }
 
+func (loop *EventLoop) SetBeforeSleepProc(fn BeforeSleepProcFn) {
+	loop.beforeSleep = fn
+}
+
 func (loop *EventLoop) AeMain() error {
 	for loop.stop == false {
+		if loop.beforeSleep != nil {
+			loop.beforeSleep()
+		}
 		fes, tes, err := loop.Wait()
 		if err != nil {
 			log.Printf("loop.Wait err: %v", err)
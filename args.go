@@ -185,3 +185,50 @@ func (c *Client) extractStr(st, ed int) (string, error) {
 	c.queryLen -= ed + 2
 	return v, nil
 }
+
+const errNotInteger = "ERR value is not an integer or out of range"
+
+// 解析整数参数，失败时直接回复错误
+func getInt64OrReply(c *Client, obj *Obj, msg string) (int64, bool) {
+	v, err := obj.ToInt64()
+	if err != nil {
+		if msg == "" {
+			msg = errNotInteger
+		}
+		c.addReplyError(msg)
+		return 0, false
+	}
+	return v, true
+}
+
+// 解析非负整数参数
+func getPositiveInt64OrReply(c *Client, obj *Obj, msg string) (int64, bool) {
+	v, ok := getInt64OrReply(c, obj, msg)
+	if !ok {
+		return 0, false
+	}
+	if v < 0 {
+		if msg == "" {
+			msg = "ERR value is out of range, must be positive"
+		}
+		c.addReplyError(msg)
+		return 0, false
+	}
+	return v, true
+}
+
+// 解析 [min, max] 范围内的整数参数
+func getRangeInt64OrReply(c *Client, obj *Obj, min, max int64, msg string) (int64, bool) {
+	v, ok := getInt64OrReply(c, obj, msg)
+	if !ok {
+		return 0, false
+	}
+	if v < min || v > max {
+		if msg == "" {
+			msg = "ERR value is out of range"
+		}
+		c.addReplyError(msg)
+		return 0, false
+	}
+	return v, true
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"log"
+	"math"
+	"strconv"
+
+	"github.com/draymonders/gmem/ae"
+)
+
+/*
+   阻塞命令 (BLPOP 等) 的通用逻辑
+   1. key 为空时 client 挂到 db.blockingKeys[key] 的等待队列上，暂停处理后续命令
+   2. 新建 list/zset key 时 signalKeyAsReady 记录就绪key
+   3. beforeSleep 里 handleClientsBlockedOnKeys 按阻塞先后顺序唤醒 client
+   4. 超时通过 ae 一次性时间事件实现
+*/
+
+type blockType int
+
+const (
+	blockType_None blockType = 0
+	blockType_List blockType = 1
+	blockType_ZSet blockType = 2
+)
+
+type blockingState struct {
+	btype     blockType
+	keys      []*Obj // 阻塞等待的keys
+	timeoutId int    // 超时时间事件id，0 表示永不超时
+
+	// list
+	wherefrom listWhere
+	whereto   listWhere
+	target    *Obj  // BLMOVE 的目标key
+	count     int64 // BLMPOP/BZMPOP 弹出个数，0 表示 BLPOP/BZPOPMIN 等单个弹出
+
+	// zset
+	zmax bool // 弹出 score 最大的元素
+}
+
+type readyKey struct {
+	db  *DB
+	key *Obj
+}
+
+// 解析秒级超时时间(支持小数)，返回毫秒
+func getTimeoutOrReply(c *Client, obj *Obj) (int64, bool) {
+	v, err := strconv.ParseFloat(obj.ToStr(), 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		c.addReplyError("ERR timeout is not a float or out of range")
+		return 0, false
+	}
+	if v < 0 {
+		c.addReplyError("ERR timeout is negative")
+		return 0, false
+	}
+	return int64(math.Ceil(v * 1000)), true
+}
+
+// blockForKeys 阻塞client，timeout 单位ms，0 表示永久阻塞
+// 调用方在此之前设置好 c.bstate 里命令相关的字段
+func blockForKeys(c *Client, btype blockType, keys []*Obj, timeout int64) {
+	c.bstate.btype = btype
+	for _, key := range keys {
+		k := key.ToStr()
+		clients := c.db.blockingKeys[k]
+		dup := false
+		for _, bc := range clients {
+			if bc == c {
+				dup = true
+				break
+			}
+		}
+		if dup { // BLPOP k k 0
+			continue
+		}
+		key.incrRefCount()
+		c.bstate.keys = append(c.bstate.keys, key)
+		c.db.blockingKeys[k] = append(clients, c)
+	}
+	if c.bstate.target != nil {
+		c.bstate.target.incrRefCount()
+	}
+	if timeout > 0 {
+		c.bstate.timeoutId = server.eventLoop.AddTimeEvent(timeout, ae.TimeEventType_Once, blockedTimeoutHandler, c)
+	}
+	c.flags |= clientFlag_Blocked
+}
+
+// unblockClient 从所有等待队列中移除client，并取消超时事件
+func unblockClient(c *Client) {
+	for _, key := range c.bstate.keys {
+		k := key.ToStr()
+		clients := c.db.blockingKeys[k]
+		for i, bc := range clients {
+			if bc == c {
+				clients = append(clients[:i], clients[i+1:]...)
+				break
+			}
+		}
+		if len(clients) == 0 {
+			delete(c.db.blockingKeys, k)
+		} else {
+			c.db.blockingKeys[k] = clients
+		}
+		key.decrRefCount()
+	}
+	if c.bstate.target != nil {
+		c.bstate.target.decrRefCount()
+	}
+	if c.bstate.timeoutId != 0 {
+		server.eventLoop.DelTimeEvent(c.bstate.timeoutId)
+	}
+	c.bstate = blockingState{}
+	c.flags &^= clientFlag_Blocked
+}
+
+// 阻塞结束后继续处理缓冲区中积压的命令，并注册写事件发送回复
+func resumeClient(c *Client) {
+	if err := processInputBuffer(c); err != nil {
+		log.Printf("client fd %v processInputBuffer err: %v", c.fd, err)
+		freeClient(c)
+	}
+}
+
+func blockedTimeoutHandler(extra interface{}) {
+	c, ok := extra.(*Client)
+	if !ok || c == nil || c.flags&clientFlag_Blocked == 0 {
+		return
+	}
+	if c.bstate.btype == blockType_List && c.bstate.target != nil {
+		c.addReplyNull()
+	} else {
+		c.addReplyNullArray()
+	}
+	unblockClient(c)
+	resumeClient(c)
+}
+
+// signalKeyAsReady 有client阻塞在该key上时，记录下来等 beforeSleep 处理
+func signalKeyAsReady(db *DB, key *Obj) {
+	k := key.ToStr()
+	if _, ok := db.blockingKeys[k]; !ok {
+		return
+	}
+	if _, ok := db.readyKeys[k]; ok {
+		return
+	}
+	db.readyKeys[k] = struct{}{}
+	key.incrRefCount()
+	server.readyKeys = append(server.readyKeys, &readyKey{db: db, key: key})
+}
+
+// scanDatabaseForReadyKeys db数据整体替换 (如 SWAPDB) 后，检查所有被阻塞的key是否已就绪
+func scanDatabaseForReadyKeys(db *DB) {
+	for k := range db.blockingKeys {
+		key := NewObjectFromStr(k)
+		if val := lookupKey(db, key, lookupFlag_NoTouch); val != nil && !keyIsExpired(db, key) &&
+			(val.gType == GType_List || val.gType == GType_ZSet) {
+			signalKeyAsReady(db, key)
+		}
+		key.decrRefCount()
+	}
+}
+
+// handleClientsBlockedOnKeys 服务阻塞在就绪key上的client
+// 服务过程中可能产生新的就绪key (如 BLMOVE 推入目标list)，循环直到没有为止
+func handleClientsBlockedOnKeys() {
+	for len(server.readyKeys) > 0 {
+		readyKeys := server.readyKeys
+		server.readyKeys = nil
+		for _, rk := range readyKeys {
+			delete(rk.db.readyKeys, rk.key.ToStr())
+			serveClientsBlockedOnKey(rk)
+			rk.key.decrRefCount()
+		}
+	}
+	updateTouchedKeysMemory()
+}
+
+func serveClientsBlockedOnKey(rk *readyKey) {
+	// unblockClient 会修改等待队列，先复制一份
+	clients := append([]*Client(nil), rk.db.blockingKeys[rk.key.ToStr()]...)
+	for _, c := range clients {
+		obj := lookupKeyWrite(rk.db, rk.key)
+		if obj == nil {
+			return
+		}
+		served := false
+		switch c.bstate.btype {
+		case blockType_List:
+			if obj.gType == GType_List {
+				served = serveClientBlockedOnList(c, rk.key, obj)
+			}
+		case blockType_ZSet:
+			if obj.gType == GType_ZSet {
+				zsetPopAndReply(c, rk.key, obj, c.bstate.zmax, c.bstate.count)
+				served = true
+			}
+		}
+		if served {
+			unblockClient(c)
+			resumeClient(c)
+		}
+	}
+}
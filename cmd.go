@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"strings"
+	"sync/atomic"
 )
 
 var (
@@ -12,10 +13,143 @@ var (
 	errArgsNumFmt = "ERR wrong number of arguments for '%s' command"
 )
 
-var cmdTable = []*Cmd{
-	{name: "COMMAND", limit: 1, fn: Command},
-	{name: "SET", limit: 3, fn: Set},
-	{name: "GET", limit: 2, fn: Get},
+var cmdTable []*Cmd
+
+// commands 命令名 -> Cmd，命令名大小写不敏感，value 只用来携带 *Cmd
+var commands *Dict
+
+// 命令回调可能间接引用 cmdTable (如阻塞命令唤醒后继续处理命令)，放在 init 中避免初始化循环
+func init() {
+	cmdTable = []*Cmd{
+		{name: "COMMAND", limit: 1, fn: Command},
+		{name: "SET", limit: 3, fn: Set, flags: cmdFlag_DenyOom},
+		{name: "GET", limit: 2, fn: Get},
+		{name: "OBJECT", limit: 2, fn: Object},
+		{name: "INFO", limit: 1, fn: Info},
+		{name: "MEMORY", limit: 2, fn: Memory},
+
+		// keyspace
+		{name: "DEL", limit: 2, fn: Del},
+		{name: "UNLINK", limit: 2, fn: Unlink},
+		{name: "EXISTS", limit: 2, fn: Exists},
+		{name: "TOUCH", limit: 2, fn: Touch},
+		{name: "TYPE", limit: 2, fn: Type},
+		{name: "RENAME", limit: 3, fn: Rename},
+		{name: "RENAMENX", limit: 3, fn: RenameNx},
+		{name: "COPY", limit: 3, fn: Copy, flags: cmdFlag_DenyOom},
+		{name: "KEYS", limit: 2, fn: Keys},
+		{name: "SCAN", limit: 2, fn: Scan},
+		{name: "RANDOMKEY", limit: 1, fn: RandomKey},
+		{name: "DBSIZE", limit: 1, fn: DbSize},
+		{name: "SELECT", limit: 2, fn: Select},
+		{name: "MOVE", limit: 3, fn: Move},
+		{name: "SWAPDB", limit: 3, fn: SwapDb},
+		{name: "FLUSHDB", limit: 1, fn: FlushDb},
+		{name: "FLUSHALL", limit: 1, fn: FlushAll},
+
+		// expire
+		{name: "EXPIRE", limit: 3, fn: Expire},
+		{name: "PEXPIRE", limit: 3, fn: PExpire},
+		{name: "EXPIREAT", limit: 3, fn: ExpireAt},
+		{name: "PEXPIREAT", limit: 3, fn: PExpireAt},
+		{name: "TTL", limit: 2, fn: Ttl},
+		{name: "PTTL", limit: 2, fn: PTtl},
+		{name: "EXPIRETIME", limit: 2, fn: ExpireTime},
+		{name: "PEXPIRETIME", limit: 2, fn: PExpireTime},
+		{name: "PERSIST", limit: 2, fn: Persist},
+
+		// list
+		{name: "LPUSH", limit: 3, fn: LPush, flags: cmdFlag_DenyOom},
+		{name: "RPUSH", limit: 3, fn: RPush, flags: cmdFlag_DenyOom},
+		{name: "LPOP", limit: 2, fn: LPop},
+		{name: "RPOP", limit: 2, fn: RPop},
+		{name: "LRANGE", limit: 4, fn: LRange},
+		{name: "LINDEX", limit: 3, fn: LIndex},
+		{name: "LLEN", limit: 2, fn: LLen},
+		{name: "LREM", limit: 4, fn: LRem},
+		{name: "LTRIM", limit: 4, fn: LTrim},
+		{name: "LSET", limit: 4, fn: LSet, flags: cmdFlag_DenyOom},
+		{name: "LINSERT", limit: 5, fn: LInsert, flags: cmdFlag_DenyOom},
+		{name: "LMOVE", limit: 5, fn: LMove, flags: cmdFlag_DenyOom},
+		{name: "BLPOP", limit: 3, fn: BLPop},
+		{name: "BRPOP", limit: 3, fn: BRPop},
+		{name: "BLMOVE", limit: 6, fn: BLMove, flags: cmdFlag_DenyOom},
+		{name: "BLMPOP", limit: 5, fn: BLMPop},
+
+		// hash
+		{name: "HSET", limit: 4, fn: HSet, flags: cmdFlag_DenyOom},
+		{name: "HSETNX", limit: 4, fn: HSetNx, flags: cmdFlag_DenyOom},
+		{name: "HGET", limit: 3, fn: HGet},
+		{name: "HMGET", limit: 3, fn: HMGet},
+		{name: "HDEL", limit: 3, fn: HDel},
+		{name: "HLEN", limit: 2, fn: HLen},
+		{name: "HEXISTS", limit: 3, fn: HExists},
+		{name: "HKEYS", limit: 2, fn: HKeys},
+		{name: "HVALS", limit: 2, fn: HVals},
+		{name: "HGETALL", limit: 2, fn: HGetAll},
+		{name: "HINCRBY", limit: 4, fn: HIncrBy, flags: cmdFlag_DenyOom},
+		{name: "HINCRBYFLOAT", limit: 4, fn: HIncrByFloat, flags: cmdFlag_DenyOom},
+		{name: "HSTRLEN", limit: 3, fn: HStrLen},
+		{name: "HRANDFIELD", limit: 2, fn: HRandField},
+		{name: "HSCAN", limit: 3, fn: HScan},
+
+		// set
+		{name: "SADD", limit: 3, fn: SAdd, flags: cmdFlag_DenyOom},
+		{name: "SREM", limit: 3, fn: SRem},
+		{name: "SISMEMBER", limit: 3, fn: SIsMember},
+		{name: "SMISMEMBER", limit: 3, fn: SMIsMember},
+		{name: "SCARD", limit: 2, fn: SCard},
+		{name: "SMEMBERS", limit: 2, fn: SMembers},
+		{name: "SPOP", limit: 2, fn: SPop},
+		{name: "SRANDMEMBER", limit: 2, fn: SRandMember},
+		{name: "SMOVE", limit: 4, fn: SMove},
+		{name: "SINTER", limit: 2, fn: SInter},
+		{name: "SINTERSTORE", limit: 3, fn: SInterStore, flags: cmdFlag_DenyOom},
+		{name: "SUNION", limit: 2, fn: SUnion},
+		{name: "SUNIONSTORE", limit: 3, fn: SUnionStore, flags: cmdFlag_DenyOom},
+		{name: "SDIFF", limit: 2, fn: SDiff},
+		{name: "SDIFFSTORE", limit: 3, fn: SDiffStore, flags: cmdFlag_DenyOom},
+		{name: "SINTERCARD", limit: 3, fn: SInterCard},
+		{name: "SSCAN", limit: 3, fn: SScan},
+
+		// zset
+		{name: "ZADD", limit: 4, fn: ZAdd, flags: cmdFlag_DenyOom},
+		{name: "ZINCRBY", limit: 4, fn: ZIncrBy, flags: cmdFlag_DenyOom},
+		{name: "ZREM", limit: 3, fn: ZRem},
+		{name: "ZSCORE", limit: 3, fn: ZScore},
+		{name: "ZMSCORE", limit: 3, fn: ZMScore},
+		{name: "ZCARD", limit: 2, fn: ZCard},
+		{name: "ZRANK", limit: 3, fn: ZRank},
+		{name: "ZREVRANK", limit: 3, fn: ZRevRank},
+		{name: "ZRANGE", limit: 4, fn: ZRange},
+		{name: "ZCOUNT", limit: 4, fn: ZCount},
+		{name: "ZPOPMIN", limit: 2, fn: ZPopMin},
+		{name: "ZPOPMAX", limit: 2, fn: ZPopMax},
+		{name: "ZUNIONSTORE", limit: 4, fn: ZUnionStore, flags: cmdFlag_DenyOom},
+		{name: "ZINTERSTORE", limit: 4, fn: ZInterStore, flags: cmdFlag_DenyOom},
+		{name: "ZDIFFSTORE", limit: 4, fn: ZDiffStore, flags: cmdFlag_DenyOom},
+		{name: "ZUNION", limit: 3, fn: ZUnion},
+		{name: "ZINTER", limit: 3, fn: ZInter},
+		{name: "ZDIFF", limit: 3, fn: ZDiff},
+		{name: "ZRANGESTORE", limit: 5, fn: ZRangeStore, flags: cmdFlag_DenyOom},
+		{name: "ZREMRANGEBYRANK", limit: 4, fn: ZRemRangeByRank},
+		{name: "ZREMRANGEBYSCORE", limit: 4, fn: ZRemRangeByScore},
+		{name: "ZREMRANGEBYLEX", limit: 4, fn: ZRemRangeByLex},
+		{name: "ZLEXCOUNT", limit: 4, fn: ZLexCount},
+		{name: "ZRANDMEMBER", limit: 2, fn: ZRandMember},
+		{name: "ZSCAN", limit: 3, fn: ZScan},
+		{name: "BZPOPMIN", limit: 3, fn: BZPopMin},
+		{name: "BZPOPMAX", limit: 3, fn: BZPopMax},
+		{name: "BZMPOP", limit: 5, fn: BZMPop},
+	}
+
+	commands = NewDict(CaseStrDictType)
+	for _, cmd := range cmdTable {
+		name, val := NewObjectFromStr(cmd.name), NewObject(0, cmd)
+		_ = commands.Add(name, val)
+		name.decrRefCount()
+		val.decrRefCount()
+	}
 }
 
 type processCmdFn func(*Client, *Cmd)
@@ -24,35 +158,46 @@ type Cmd struct {
 	name  string
 	limit int // 命令支持的个数
 	fn    processCmdFn
+	flags int // cmdFlag_*
 }
 
+const (
+	cmdFlag_DenyOom = 1 << 0 // 可能增加内存，超过 maxmemory 时拒绝执行
+)
+
 func lookupCmd(c *Client) *Cmd {
-	if len(c.args) <= 1 {
+	if len(c.args) == 0 {
 		return nil
 	}
-	v := c.args[0].ToStr()
-	for _, cmd := range cmdTable {
-		if cmd.name == strings.ToUpper(v) {
-			return cmd
-		}
+	val := commands.Get(c.args[0])
+	if val == nil {
+		return nil
 	}
-	return nil
+	return val.ptr.(*Cmd)
 }
 
 // 校验输入参数格式
 func checkLimit(c *Client, cmd *Cmd) error {
 	if len(c.args) < cmd.limit {
-		return fmt.Errorf(errArgsNumFmt, cmd.name)
+		return fmt.Errorf(errArgsNumFmt, strings.ToLower(cmd.name))
 	}
 	return nil
 }
 
+// 校验value类型，不匹配时回复 WRONGTYPE
+func checkType(c *Client, obj *Obj, gType GType) bool {
+	if obj.gType != gType {
+		c.addReplyError(respWrongType)
+		return false
+	}
+	return true
+}
+
 func Command(c *Client, cmd *Cmd) {
 	if c == nil {
 		return
 	}
 	c.reply.Add(NewObjectFromStr(respOK))
-	freeClientArgs(c, 1)
 	return
 }
 
@@ -60,19 +205,11 @@ func Set(c *Client, cmd *Cmd) {
 	if c == nil {
 		return
 	}
+	// 整数值转为 int 编码
+	c.args[2] = tryObjectEncoding(c.args[2])
 	k, v := c.args[1], c.args[2]
-	var err error
-	if obj := c.db.dict.Get(k); obj != nil {
-		err = c.db.dict.Set(k, v)
-	} else {
-		err = c.db.dict.Add(k, v)
-	}
-	if err != nil {
-		c.reply.Add(NewObjectFromStr(fmt.Sprintf(respFail, err.Error())))
-	} else {
-		c.reply.Add(NewObjectFromStr(respOK))
-	}
-	freeClientArgs(c, 3)
+	setKey(c.db, k, v)
+	c.reply.Add(NewObjectFromStr(respOK))
 	return
 }
 
@@ -81,14 +218,150 @@ func Get(c *Client, cmd *Cmd) {
 		return
 	}
 	k := c.args[1]
-	v := c.db.dict.Get(k)
+	v := lookupKeyRead(c.db, k)
 
 	if v == nil {
 		c.reply.Add(NewObjectFromStr(fmt.Sprintf(respFmt, "null")))
-	} else {
+	} else if checkType(c, v, GType_Str) {
 		c.reply.Add(NewObjectFromStr(fmt.Sprintf(respFmt, v.ToStr())))
 	}
-
-	freeClientArgs(c, 2)
 	return
 }
+
+// OBJECT ENCODING|REFCOUNT|IDLETIME|FREQ key | HELP
+// 查询不更新key的访问信息
+func Object(c *Client, cmd *Cmd) {
+	if c == nil {
+		return
+	}
+	sub := strings.ToUpper(c.args[1].ToStr())
+	if sub == "HELP" && len(c.args) == 2 {
+		help := []string{
+			"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"ENCODING <key>",
+			"    Return the kind of internal representation used in order to store the value",
+			"    associated with a <key>.",
+			"FREQ <key>",
+			"    Return the access frequency index of the <key>. The returned integer is",
+			"    proportional to the logarithm of the recent access frequency of the key.",
+			"IDLETIME <key>",
+			"    Return the idle time of the <key>, that is the approximated number of",
+			"    seconds elapsed since the last access to the key.",
+			"REFCOUNT <key>",
+			"    Return the number of references of the value associated with the specified",
+			"    <key>.",
+		}
+		c.addReplyArrayLen(len(help))
+		for _, line := range help {
+			c.addReplyStatus(line)
+		}
+		return
+	}
+	if len(c.args) != 3 || (sub != "ENCODING" && sub != "REFCOUNT" && sub != "IDLETIME" && sub != "FREQ") {
+		c.addReplyErrorf("ERR unknown subcommand or wrong number of arguments for '%s'", c.args[1].ToStr())
+		return
+	}
+	v := lookupKeyReadWithFlags(c.db, c.args[2], lookupFlag_NoTouch)
+	if v == nil {
+		c.addReplyNull()
+		return
+	}
+	lfu := server.maxmemoryPolicy&maxmemoryFlag_LFU != 0
+	switch sub {
+	case "ENCODING":
+		c.addReplyBulk(v.encodingName())
+	case "REFCOUNT":
+		c.addReplyInt(int64(v.getRefCount()))
+	case "IDLETIME":
+		if lfu {
+			c.addReplyError("ERR An LFU maxmemory policy is selected, idle time not tracked. " +
+				"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+			return
+		}
+		c.addReplyInt(int64(estimateObjectIdleTime(v) / 1000))
+	case "FREQ":
+		if !lfu {
+			c.addReplyError("ERR An LFU maxmemory policy is not selected, access frequency not tracked. " +
+				"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+			return
+		}
+		c.addReplyInt(int64(lfuDecrAndReturn(v)))
+	}
+}
+
+// genInfoString 生成 INFO 命令的内容，section 为空时返回全部
+func genInfoString(section string) string {
+	var sb strings.Builder
+	all := section == "" || section == "all" || section == "default"
+	if all || section == "server" {
+		sb.WriteString("# Server\r\n")
+		fmt.Fprintf(&sb, "tcp_port:%d\r\n", server.port)
+		fmt.Fprintf(&sb, "hz:%d\r\n", 1000/serverCronInterval)
+	}
+	if all || section == "clients" {
+		blocked := 0
+		for _, c := range server.clients {
+			if c.flags&clientFlag_Blocked != 0 {
+				blocked++
+			}
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString("# Clients\r\n")
+		fmt.Fprintf(&sb, "connected_clients:%d\r\n", len(server.clients))
+		fmt.Fprintf(&sb, "blocked_clients:%d\r\n", blocked)
+	}
+	if all || section == "memory" {
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString("# Memory\r\n")
+		used, dataset := usedMemory(), datasetMemory()
+		fmt.Fprintf(&sb, "used_memory:%d\r\n", used)
+		fmt.Fprintf(&sb, "used_memory_peak:%d\r\n", server.statPeakMemory)
+		fmt.Fprintf(&sb, "used_memory_overhead:%d\r\n", used-dataset)
+		fmt.Fprintf(&sb, "used_memory_dataset:%d\r\n", dataset)
+		fmt.Fprintf(&sb, "maxmemory:%d\r\n", server.maxmemory)
+		fmt.Fprintf(&sb, "maxmemory_policy:%s\r\n", maxmemoryPolicyName(server.maxmemoryPolicy))
+		fmt.Fprintf(&sb, "lazyfree_pending_objects:%d\r\n", atomic.LoadInt64(&lazyfreePendingObjects))
+	}
+	if all || section == "stats" {
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString("# Stats\r\n")
+		fmt.Fprintf(&sb, "expired_keys:%d\r\n", server.statExpiredKeys)
+		fmt.Fprintf(&sb, "expired_stale_perc:%.2f\r\n", server.statExpiredStalePerc)
+		fmt.Fprintf(&sb, "expired_time_cap_reached_count:%d\r\n", server.statExpiredTimeCapReachedCount)
+		fmt.Fprintf(&sb, "evicted_keys:%d\r\n", server.statEvictedKeys)
+		fmt.Fprintf(&sb, "lazyfreed_objects:%d\r\n", atomic.LoadInt64(&lazyfreedObjects))
+		fmt.Fprintf(&sb, "keyspace_hits:%d\r\n", server.statKeyspaceHits)
+		fmt.Fprintf(&sb, "keyspace_misses:%d\r\n", server.statKeyspaceMisses)
+	}
+	if all || section == "keyspace" {
+		if sb.Len() > 0 {
+			sb.WriteString("\r\n")
+		}
+		sb.WriteString("# Keyspace\r\n")
+		for _, db := range server.dbs {
+			if keys := db.dict.Len(); keys > 0 {
+				fmt.Fprintf(&sb, "db%d:keys=%d,expires=%d\r\n", db.id, keys, db.expires.Len())
+			}
+		}
+	}
+	return sb.String()
+}
+
+// INFO [section]
+func Info(c *Client, cmd *Cmd) {
+	if len(c.args) > 2 {
+		c.addReplyError(respSyntaxErr)
+		return
+	}
+	section := ""
+	if len(c.args) == 2 {
+		section = strings.ToLower(c.args[1].ToStr())
+	}
+	c.addReplyBulk(genInfoString(section))
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"math"
+	"strings"
+
+	"github.com/draymonders/gmem/ae"
+)
+
+/*
+   过期相关命令，过期时间以毫秒级unix时间戳存放在 db.expires 中
+*/
+
+const (
+	expireFlag_NX = 1 << iota
+	expireFlag_XX
+	expireFlag_GT
+	expireFlag_LT
+)
+
+func parseExpireFlags(c *Client, args []*Obj) (int, bool) {
+	flags := 0
+	for _, arg := range args {
+		switch strings.ToUpper(arg.ToStr()) {
+		case "NX":
+			flags |= expireFlag_NX
+		case "XX":
+			flags |= expireFlag_XX
+		case "GT":
+			flags |= expireFlag_GT
+		case "LT":
+			flags |= expireFlag_LT
+		default:
+			c.addReplyErrorf("ERR Unsupported option %s", arg.ToStr())
+			return 0, false
+		}
+	}
+	if flags&expireFlag_NX != 0 && flags&(expireFlag_XX|expireFlag_GT|expireFlag_LT) != 0 {
+		c.addReplyError("ERR NX and XX, GT or LT options at the same time are not compatible")
+		return 0, false
+	}
+	if flags&expireFlag_GT != 0 && flags&expireFlag_LT != 0 {
+		c.addReplyError("ERR GT and LT options at the same time are not compatible")
+		return 0, false
+	}
+	return flags, true
+}
+
+// expireGeneric EXPIRE/PEXPIRE/EXPIREAT/PEXPIREAT 的通用实现
+// basetime 为 0 表示绝对时间，unitMs 为参数单位对应的毫秒数
+func expireGeneric(c *Client, basetime int64, unitMs int64) {
+	key := c.args[1]
+	when, ok := getInt64OrReply(c, c.args[2], "")
+	if !ok {
+		return
+	}
+	flags, ok := parseExpireFlags(c, c.args[3:])
+	if !ok {
+		return
+	}
+	// 换算成毫秒时间戳，溢出时报错
+	if when > math.MaxInt64/unitMs || when < math.MinInt64/unitMs {
+		c.addReplyErrorf("ERR invalid expire time in '%s' command", strings.ToLower(c.args[0].ToStr()))
+		return
+	}
+	when *= unitMs
+	if (when > 0 && basetime > math.MaxInt64-when) || (when < 0 && basetime < math.MinInt64-when) {
+		c.addReplyErrorf("ERR invalid expire time in '%s' command", strings.ToLower(c.args[0].ToStr()))
+		return
+	}
+	when += basetime
+
+	if lookupKeyWrite(c.db, key) == nil {
+		c.addReplyInt(0)
+		return
+	}
+	if flags != 0 {
+		cur := getExpire(c.db, key)
+		switch {
+		case flags&expireFlag_NX != 0 && cur != -1:
+			c.addReplyInt(0)
+			return
+		case flags&expireFlag_XX != 0 && cur == -1:
+			c.addReplyInt(0)
+			return
+		// 没有过期时间视为无限大
+		case flags&expireFlag_GT != 0 && (cur == -1 || when <= cur):
+			c.addReplyInt(0)
+			return
+		case flags&expireFlag_LT != 0 && cur != -1 && when >= cur:
+			c.addReplyInt(0)
+			return
+		}
+	}
+	if when <= ae.GetUnixTime() {
+		dbDelete(c.db, key)
+	} else {
+		setExpire(c.db, key, when)
+	}
+	c.addReplyInt(1)
+}
+
+// EXPIRE key seconds [NX|XX|GT|LT]
+func Expire(c *Client, cmd *Cmd) {
+	expireGeneric(c, ae.GetUnixTime(), 1000)
+}
+
+// PEXPIRE key milliseconds [NX|XX|GT|LT]
+func PExpire(c *Client, cmd *Cmd) {
+	expireGeneric(c, ae.GetUnixTime(), 1)
+}
+
+// EXPIREAT key unix-time-seconds [NX|XX|GT|LT]
+func ExpireAt(c *Client, cmd *Cmd) {
+	expireGeneric(c, 0, 1000)
+}
+
+// PEXPIREAT key unix-time-milliseconds [NX|XX|GT|LT]
+func PExpireAt(c *Client, cmd *Cmd) {
+	expireGeneric(c, 0, 1)
+}
+
+// ttlGeneric key不存在回复 -2，没有过期时间回复 -1
+// relative 为 false 时回复绝对时间戳
+func ttlGeneric(c *Client, ms bool, relative bool) {
+	key := c.args[1]
+	if lookupKeyRead(c.db, key) == nil {
+		c.addReplyInt(-2)
+		return
+	}
+	when := getExpire(c.db, key)
+	if when == -1 {
+		c.addReplyInt(-1)
+		return
+	}
+	if relative {
+		when -= ae.GetUnixTime()
+		if when < 0 {
+			when = 0
+		}
+	}
+	if !ms {
+		if relative {
+			when = (when + 500) / 1000
+		} else {
+			when /= 1000
+		}
+	}
+	c.addReplyInt(when)
+}
+
+// TTL key
+func Ttl(c *Client, cmd *Cmd) {
+	ttlGeneric(c, false, true)
+}
+
+// PTTL key
+func PTtl(c *Client, cmd *Cmd) {
+	ttlGeneric(c, true, true)
+}
+
+// EXPIRETIME key
+func ExpireTime(c *Client, cmd *Cmd) {
+	ttlGeneric(c, false, false)
+}
+
+// PEXPIRETIME key
+func PExpireTime(c *Client, cmd *Cmd) {
+	ttlGeneric(c, true, false)
+}
+
+// PERSIST key
+func Persist(c *Client, cmd *Cmd) {
+	if lookupKeyWrite(c.db, c.args[1]) == nil || !removeExpire(c.db, c.args[1]) {
+		c.addReplyInt(0)
+		return
+	}
+	c.addReplyInt(1)
+}
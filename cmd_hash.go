@@ -0,0 +1,547 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+/*
+   hash 类型命令
+   field 数和 value 长度较小时使用 listpack 编码，超过阈值转为 Dict
+*/
+
+const (
+	hashMaxListpackEntries = 128 // listpack 编码最多的 field 数
+	hashMaxListpackValue   = 64  // listpack 编码 field/value 的最大长度
+)
+
+// hrandfieldSubStrategyMul HRANDFIELD 的 count 乘以该值仍超过 field 数时复制全部 field 挑选，否则随机抽样
+const hrandfieldSubStrategyMul = 3
+
+func hashTypeLength(obj *Obj) int {
+	if obj.encoding == GEncoding_Listpack {
+		return obj.ptr.(*Listpack).Len() / 2
+	}
+	return obj.ptr.(*Dict).Len()
+}
+
+func hashTypeGet(obj *Obj, field *Obj) *Obj {
+	if obj.encoding == GEncoding_Listpack {
+		lp := obj.ptr.(*Listpack)
+		if i := lp.FindPair(field); i >= 0 {
+			return lp.entries[i+1]
+		}
+		return nil
+	}
+	return obj.ptr.(*Dict).Get(field)
+}
+
+// listpack 转为 Dict 编码
+func hashTypeConvert(obj *Obj) {
+	if obj.encoding != GEncoding_Listpack {
+		return
+	}
+	lp := obj.ptr.(*Listpack)
+	d := NewDict(StrDictType)
+	for i := 0; i+1 < len(lp.entries); i += 2 {
+		_ = d.Add(lp.entries[i], lp.entries[i+1])
+		lp.entries[i].decrRefCount()
+		lp.entries[i+1].decrRefCount()
+	}
+	obj.ptr = d
+	obj.encoding = GEncoding_Hashtable
+}
+
+// 写入前检查参数长度，超过阈值提前转换编码
+func hashTypeTryConversion(obj *Obj, args []*Obj) {
+	if obj.encoding != GEncoding_Listpack {
+		return
+	}
+	for _, arg := range args {
+		if arg.encoding != GEncoding_Int && len(arg.ToStr()) > hashMaxListpackValue {
+			hashTypeConvert(obj)
+			return
+		}
+	}
+}
+
+// hashTypeSet 设置 field，返回 field 是否已存在
+func hashTypeSet(obj *Obj, field, val *Obj) bool {
+	if obj.encoding == GEncoding_Listpack {
+		lp := obj.ptr.(*Listpack)
+		if i := lp.FindPair(field); i >= 0 {
+			lp.Replace(i+1, val)
+			return true
+		}
+		lp.AppendPair(field, val)
+		if lp.Len()/2 > hashMaxListpackEntries {
+			hashTypeConvert(obj)
+		}
+		return false
+	}
+	d := obj.ptr.(*Dict)
+	if d.Set(field, val) == nil {
+		return true
+	}
+	_ = d.Add(field, val)
+	return false
+}
+
+func hashTypeDelete(obj *Obj, field *Obj) bool {
+	if obj.encoding == GEncoding_Listpack {
+		lp := obj.ptr.(*Listpack)
+		if i := lp.FindPair(field); i >= 0 {
+			lp.DeletePair(i)
+			return true
+		}
+		return false
+	}
+	return obj.ptr.(*Dict).Del(field)
+}
+
+// hashTypeRange 遍历 field,value，fn 返回 false 时停止
+func hashTypeRange(obj *Obj, fn func(field, val *Obj) bool) {
+	if obj.encoding == GEncoding_Listpack {
+		lp := obj.ptr.(*Listpack)
+		for i := 0; i+1 < len(lp.entries); i += 2 {
+			if !fn(lp.entries[i], lp.entries[i+1]) {
+				return
+			}
+		}
+		return
+	}
+	obj.ptr.(*Dict).Range(fn)
+}
+
+// hashTypeRandom 随机返回一个 field,value，hash 不能为空
+func hashTypeRandom(obj *Obj) (field, val *Obj) {
+	if obj.encoding == GEncoding_Listpack {
+		lp := obj.ptr.(*Listpack)
+		i := rand.Intn(lp.Len()/2) * 2
+		return lp.entries[i], lp.entries[i+1]
+	}
+	entry := obj.ptr.(*Dict).FairRandomGet()
+	return entry.key, entry.val
+}
+
+// hashTypeDup 复制hash，保持原编码
+func hashTypeDup(obj *Obj) *Obj {
+	if obj.encoding == GEncoding_Listpack {
+		dup := createHashObject()
+		lp := dup.ptr.(*Listpack)
+		hashTypeRange(obj, func(field, val *Obj) bool {
+			lp.AppendPair(field, val)
+			return true
+		})
+		return dup
+	}
+	d := NewDict(StrDictType)
+	hashTypeRange(obj, func(field, val *Obj) bool {
+		_ = d.Add(field, val)
+		return true
+	})
+	dup := NewObject(GType_Dict, d)
+	dup.encoding = GEncoding_Hashtable
+	return dup
+}
+
+// 读取hash，key不存在返回nil，类型不对时回复错误并返回 ok=false
+func lookupHashRead(c *Client, key *Obj) (*Obj, bool) {
+	obj := lookupKeyRead(c.db, key)
+	if obj == nil {
+		return nil, true
+	}
+	if !checkType(c, obj, GType_Dict) {
+		return nil, false
+	}
+	return obj, true
+}
+
+// 写hash，key不存在返回nil，类型不对时回复错误并返回 ok=false
+func lookupHashWrite(c *Client, key *Obj) (*Obj, bool) {
+	obj := lookupKeyWrite(c.db, key)
+	if obj == nil {
+		return nil, true
+	}
+	if !checkType(c, obj, GType_Dict) {
+		return nil, false
+	}
+	return obj, true
+}
+
+// 写hash，key不存在时创建
+func lookupHashWriteOrCreate(c *Client, key *Obj) *Obj {
+	obj := lookupKeyWrite(c.db, key)
+	if obj == nil {
+		obj = createHashObject()
+		dbAdd(c.db, key, obj)
+		obj.decrRefCount()
+		return obj
+	}
+	if !checkType(c, obj, GType_Dict) {
+		return nil
+	}
+	return obj
+}
+
+// HSET key field value [field value ...]
+func HSet(c *Client, cmd *Cmd) {
+	if len(c.args)%2 != 0 {
+		c.addReplyErrorf(errArgsNumFmt, strings.ToLower(c.args[0].ToStr()))
+		return
+	}
+	obj := lookupHashWriteOrCreate(c, c.args[1])
+	if obj == nil {
+		return
+	}
+	hashTypeTryConversion(obj, c.args[2:])
+	created := 0
+	for i := 2; i < len(c.args); i += 2 {
+		c.args[i+1] = tryObjectEncoding(c.args[i+1])
+		if !hashTypeSet(obj, c.args[i], c.args[i+1]) {
+			created++
+		}
+	}
+	c.addReplyInt(int64(created))
+}
+
+// HSETNX key field value
+func HSetNx(c *Client, cmd *Cmd) {
+	obj := lookupHashWriteOrCreate(c, c.args[1])
+	if obj == nil {
+		return
+	}
+	if hashTypeGet(obj, c.args[2]) != nil {
+		c.addReplyInt(0)
+		return
+	}
+	hashTypeTryConversion(obj, c.args[2:4])
+	c.args[3] = tryObjectEncoding(c.args[3])
+	hashTypeSet(obj, c.args[2], c.args[3])
+	c.addReplyInt(1)
+}
+
+// HGET key field
+func HGet(c *Client, cmd *Cmd) {
+	obj, ok := lookupHashRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if obj == nil {
+		c.addReplyNull()
+		return
+	}
+	if val := hashTypeGet(obj, c.args[2]); val != nil {
+		c.addReplyBulkObj(val)
+	} else {
+		c.addReplyNull()
+	}
+}
+
+// HMGET key field [field ...]
+func HMGet(c *Client, cmd *Cmd) {
+	obj, ok := lookupHashRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	c.addReplyArrayLen(len(c.args) - 2)
+	for _, field := range c.args[2:] {
+		var val *Obj
+		if obj != nil {
+			val = hashTypeGet(obj, field)
+		}
+		if val != nil {
+			c.addReplyBulkObj(val)
+		} else {
+			c.addReplyNull()
+		}
+	}
+}
+
+// HDEL key field [field ...]
+func HDel(c *Client, cmd *Cmd) {
+	key := c.args[1]
+	obj, ok := lookupHashWrite(c, key)
+	if !ok {
+		return
+	}
+	if obj == nil {
+		c.addReplyInt(0)
+		return
+	}
+	deleted := 0
+	for _, field := range c.args[2:] {
+		if hashTypeDelete(obj, field) {
+			deleted++
+		}
+		if hashTypeLength(obj) == 0 {
+			dbDelete(c.db, key)
+			break
+		}
+	}
+	c.addReplyInt(int64(deleted))
+}
+
+// HLEN key
+func HLen(c *Client, cmd *Cmd) {
+	obj, ok := lookupHashRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if obj == nil {
+		c.addReplyInt(0)
+		return
+	}
+	c.addReplyInt(int64(hashTypeLength(obj)))
+}
+
+// HEXISTS key field
+func HExists(c *Client, cmd *Cmd) {
+	obj, ok := lookupHashRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if obj != nil && hashTypeGet(obj, c.args[2]) != nil {
+		c.addReplyInt(1)
+	} else {
+		c.addReplyInt(0)
+	}
+}
+
+const (
+	hashReplyKeys   = 1 << 0
+	hashReplyValues = 1 << 1
+)
+
+func hashGetAllGeneric(c *Client, flags int) {
+	obj, ok := lookupHashRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if obj == nil {
+		c.addReplyArrayLen(0)
+		return
+	}
+	n := hashTypeLength(obj)
+	if flags&hashReplyKeys != 0 && flags&hashReplyValues != 0 {
+		n *= 2
+	}
+	c.addReplyArrayLen(n)
+	hashTypeRange(obj, func(field, val *Obj) bool {
+		if flags&hashReplyKeys != 0 {
+			c.addReplyBulkObj(field)
+		}
+		if flags&hashReplyValues != 0 {
+			c.addReplyBulkObj(val)
+		}
+		return true
+	})
+}
+
+// HKEYS key
+func HKeys(c *Client, cmd *Cmd) {
+	hashGetAllGeneric(c, hashReplyKeys)
+}
+
+// HVALS key
+func HVals(c *Client, cmd *Cmd) {
+	hashGetAllGeneric(c, hashReplyValues)
+}
+
+// HGETALL key
+func HGetAll(c *Client, cmd *Cmd) {
+	hashGetAllGeneric(c, hashReplyKeys|hashReplyValues)
+}
+
+// HINCRBY key field increment
+func HIncrBy(c *Client, cmd *Cmd) {
+	incr, ok := getInt64OrReply(c, c.args[3], "")
+	if !ok {
+		return
+	}
+	obj := lookupHashWriteOrCreate(c, c.args[1])
+	if obj == nil {
+		return
+	}
+	var cur int64
+	if val := hashTypeGet(obj, c.args[2]); val != nil {
+		v, err := val.ToInt64()
+		if err != nil {
+			c.addReplyError("ERR hash value is not an integer")
+			return
+		}
+		cur = v
+	}
+	if (incr < 0 && cur < 0 && incr < math.MinInt64-cur) ||
+		(incr > 0 && cur > 0 && incr > math.MaxInt64-cur) {
+		c.addReplyError("ERR increment or decrement would overflow")
+		return
+	}
+	cur += incr
+	val := NewObjectFromInt64(cur)
+	hashTypeSet(obj, c.args[2], val)
+	val.decrRefCount()
+	c.addReplyInt(cur)
+}
+
+// HINCRBYFLOAT key field increment
+func HIncrByFloat(c *Client, cmd *Cmd) {
+	incr, err := strconv.ParseFloat(c.args[3].ToStr(), 64)
+	if err != nil || math.IsNaN(incr) || math.IsInf(incr, 0) {
+		c.addReplyError("ERR value is not a valid float")
+		return
+	}
+	obj := lookupHashWriteOrCreate(c, c.args[1])
+	if obj == nil {
+		return
+	}
+	var cur float64
+	if val := hashTypeGet(obj, c.args[2]); val != nil {
+		v, err := strconv.ParseFloat(val.ToStr(), 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			c.addReplyError("ERR hash value is not a float")
+			return
+		}
+		cur = v
+	}
+	cur += incr
+	if math.IsNaN(cur) || math.IsInf(cur, 0) {
+		c.addReplyError("ERR increment would produce NaN or Infinity")
+		return
+	}
+	str := strconv.FormatFloat(cur, 'f', -1, 64)
+	val := NewObjectFromStr(str)
+	hashTypeTryConversion(obj, []*Obj{val})
+	hashTypeSet(obj, c.args[2], val)
+	val.decrRefCount()
+	c.addReplyBulk(str)
+}
+
+// HSTRLEN key field
+func HStrLen(c *Client, cmd *Cmd) {
+	obj, ok := lookupHashRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	var val *Obj
+	if obj != nil {
+		val = hashTypeGet(obj, c.args[2])
+	}
+	if val == nil {
+		c.addReplyInt(0)
+		return
+	}
+	c.addReplyInt(int64(len(val.ToStr())))
+}
+
+// HRANDFIELD key [count [WITHVALUES]]
+func HRandField(c *Client, cmd *Cmd) {
+	if len(c.args) > 4 {
+		c.addReplyError(respSyntaxErr)
+		return
+	}
+	obj, ok := lookupHashRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if len(c.args) == 2 {
+		if obj == nil {
+			c.addReplyNull()
+			return
+		}
+		field, _ := hashTypeRandom(obj)
+		c.addReplyBulkObj(field)
+		return
+	}
+
+	// 和 redis 一样限制范围，避免 -count 溢出
+	count, ok := getRangeInt64OrReply(c, c.args[2], -math.MaxInt64/2, math.MaxInt64/2, "")
+	if !ok {
+		return
+	}
+	withValues := false
+	if len(c.args) == 4 {
+		if strings.ToUpper(c.args[3].ToStr()) != "WITHVALUES" {
+			c.addReplyError(respSyntaxErr)
+			return
+		}
+		withValues = true
+	}
+	if obj == nil || count == 0 {
+		c.addReplyArrayLen(0)
+		return
+	}
+	replyPair := func(field, val *Obj) {
+		c.addReplyBulkObj(field)
+		if withValues {
+			c.addReplyBulkObj(val)
+		}
+	}
+	multi := 1
+	if withValues {
+		multi = 2
+	}
+	if count < 0 { // 可重复
+		c.addReplyArrayLen(int(-count) * multi)
+		for i := int64(0); i < -count; i++ {
+			replyPair(hashTypeRandom(obj))
+		}
+		return
+	}
+
+	// 不重复
+	size := int64(hashTypeLength(obj))
+	var picked [][2]*Obj
+	switch {
+	case count >= size:
+		// 返回全部 field
+		picked = make([][2]*Obj, 0, size)
+		hashTypeRange(obj, func(field, val *Obj) bool {
+			picked = append(picked, [2]*Obj{field, val})
+			return true
+		})
+	case count*hrandfieldSubStrategyMul > size:
+		// count 接近 field 数，复制全部 field 后随机挑选
+		all := make([][2]*Obj, 0, size)
+		hashTypeRange(obj, func(field, val *Obj) bool {
+			all = append(all, [2]*Obj{field, val})
+			return true
+		})
+		for i := 0; i < int(count); i++ {
+			j := i + rand.Intn(len(all)-i)
+			all[i], all[j] = all[j], all[i]
+		}
+		picked = all[:count]
+	default:
+		// count 远小于 field 数，随机抽样去重，不用复制整个hash
+		picked = make([][2]*Obj, 0, count)
+		seen := make(map[string]struct{}, count)
+		for int64(len(picked)) < count {
+			field, val := hashTypeRandom(obj)
+			if _, ok := seen[field.ToStr()]; ok {
+				continue
+			}
+			seen[field.ToStr()] = struct{}{}
+			picked = append(picked, [2]*Obj{field, val})
+		}
+	}
+	c.addReplyArrayLen(len(picked) * multi)
+	for _, pair := range picked {
+		replyPair(pair[0], pair[1])
+	}
+}
+
+// HSCAN key cursor [MATCH pattern] [COUNT count]
+func HScan(c *Client, cmd *Cmd) {
+	obj, ok := lookupHashRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if obj == nil {
+		c.addReplyRaw(respEmptyScan)
+		return
+	}
+	scanGeneric(c, obj, 2)
+}
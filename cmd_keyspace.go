@@ -0,0 +1,352 @@
+package main
+
+import (
+	"strings"
+)
+
+/*
+   通用 key 操作命令，不区分value类型
+*/
+
+const errDbIndexOutOfRange = "ERR DB index is out of range"
+
+var typeNames = map[GType]string{
+	GType_Str:  "string",
+	GType_List: "list",
+	GType_Dict: "hash",
+	Gtype_Set:  "set",
+	GType_ZSet: "zset",
+}
+
+func (obj *Obj) typeName() string {
+	if name, ok := typeNames[obj.gType]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// dupObject 深拷贝value，用于 COPY
+func dupObject(obj *Obj) *Obj {
+	switch obj.gType {
+	case GType_List:
+		return listTypeDup(obj)
+	case GType_Dict:
+		return hashTypeDup(obj)
+	case Gtype_Set:
+		return setTypeDup(obj)
+	case GType_ZSet:
+		return zsetDup(obj)
+	default:
+		return dupStringObject(obj)
+	}
+}
+
+func delGeneric(c *Client, lazy bool) {
+	deleted := int64(0)
+	for _, key := range c.args[1:] {
+		expireIfNeeded(c.db, key)
+		if dbGenericDelete(c.db, key, lazy) {
+			deleted++
+		}
+	}
+	c.addReplyInt(deleted)
+}
+
+// DEL key [key ...]，开启 lazyfree-lazy-user-del 时等同于 UNLINK
+func Del(c *Client, cmd *Cmd) {
+	delGeneric(c, server.lazyfreeLazyUserDel)
+}
+
+// UNLINK key [key ...]，大的value在后台释放
+func Unlink(c *Client, cmd *Cmd) {
+	delGeneric(c, true)
+}
+
+// EXISTS key [key ...]，重复的key重复计数
+func Exists(c *Client, cmd *Cmd) {
+	count := int64(0)
+	for _, key := range c.args[1:] {
+		if lookupKeyRead(c.db, key) != nil {
+			count++
+		}
+	}
+	c.addReplyInt(count)
+}
+
+// TOUCH key [key ...]
+func Touch(c *Client, cmd *Cmd) {
+	Exists(c, cmd)
+}
+
+// TYPE key
+func Type(c *Client, cmd *Cmd) {
+	obj := lookupKeyRead(c.db, c.args[1])
+	if obj == nil {
+		c.addReplyStatus("none")
+		return
+	}
+	c.addReplyStatus(obj.typeName())
+}
+
+func renameGeneric(c *Client, nx bool) {
+	src, dst := c.args[1], c.args[2]
+	obj := lookupKeyWrite(c.db, src)
+	if obj == nil {
+		c.addReplyError("ERR no such key")
+		return
+	}
+	if Equal(src, dst) {
+		if nx {
+			c.addReplyInt(0)
+		} else {
+			c.addReplyStatus("OK")
+		}
+		return
+	}
+	if lookupKeyWrite(c.db, dst) != nil {
+		if nx {
+			c.addReplyInt(0)
+			return
+		}
+		dbDelete(c.db, dst)
+	}
+	// 保留原key的过期时间，先删除再添加，value的内存只计入一次
+	expire := getExpire(c.db, src)
+	obj.incrRefCount()
+	dbDelete(c.db, src)
+	dbAdd(c.db, dst, obj)
+	if expire != -1 {
+		setExpire(c.db, dst, expire)
+	}
+	obj.decrRefCount()
+	if nx {
+		c.addReplyInt(1)
+	} else {
+		c.addReplyStatus("OK")
+	}
+}
+
+// RENAME key newkey
+func Rename(c *Client, cmd *Cmd) {
+	renameGeneric(c, false)
+}
+
+// RENAMENX key newkey
+func RenameNx(c *Client, cmd *Cmd) {
+	renameGeneric(c, true)
+}
+
+// COPY source destination [DB destination-db] [REPLACE]
+func Copy(c *Client, cmd *Cmd) {
+	src, dst := c.args[1], c.args[2]
+	dstDb := c.db
+	replace := false
+	for i := 3; i < len(c.args); i++ {
+		opt := strings.ToUpper(c.args[i].ToStr())
+		switch {
+		case opt == "REPLACE":
+			replace = true
+		case opt == "DB" && i+1 < len(c.args):
+			id, ok := getInt64OrReply(c, c.args[i+1], "")
+			if !ok {
+				return
+			}
+			if id < 0 || id >= int64(len(server.dbs)) {
+				c.addReplyError(errDbIndexOutOfRange)
+				return
+			}
+			dstDb = server.dbs[id]
+			i++
+		default:
+			c.addReplyError(respSyntaxErr)
+			return
+		}
+	}
+	if dstDb == c.db && Equal(src, dst) {
+		c.addReplyError("ERR source and destination objects are the same")
+		return
+	}
+	obj := lookupKeyRead(c.db, src)
+	if obj == nil {
+		c.addReplyInt(0)
+		return
+	}
+	if lookupKeyWrite(dstDb, dst) != nil {
+		if !replace {
+			c.addReplyInt(0)
+			return
+		}
+		dbDelete(dstDb, dst)
+	}
+	dup := dupObject(obj)
+	dbAdd(dstDb, dst, dup)
+	dup.decrRefCount()
+	if expire := getExpire(c.db, src); expire != -1 {
+		setExpire(dstDb, dst, expire)
+	}
+	c.addReplyInt(1)
+}
+
+// KEYS pattern，已过期的key在遍历时删除
+func Keys(c *Client, cmd *Cmd) {
+	pattern := c.args[1].ToStr()
+	allKeys := pattern == "*"
+	var keys []*Obj
+	it := c.db.dict.GetSafeIterator()
+	for entry := it.Next(); entry != nil; entry = it.Next() {
+		key := entry.key
+		if !allKeys && !stringMatch(pattern, key.ToStr(), false) {
+			continue
+		}
+		key.incrRefCount()
+		expired := expireIfNeeded(c.db, key)
+		key.decrRefCount()
+		if !expired {
+			keys = append(keys, key)
+		}
+	}
+	it.Release()
+	c.addReplyArrayLen(len(keys))
+	for _, key := range keys {
+		c.addReplyBulkObj(key)
+	}
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func Scan(c *Client, cmd *Cmd) {
+	scanGeneric(c, nil, 1)
+}
+
+// RANDOMKEY
+func RandomKey(c *Client, cmd *Cmd) {
+	// 全是已过期的key时避免死循环
+	const maxTries = 100
+	for tries := 0; ; tries++ {
+		entry := c.db.dict.FairRandomGet()
+		if entry == nil {
+			c.addReplyNull()
+			return
+		}
+		key := entry.key
+		if tries < maxTries && keyIsExpired(c.db, key) {
+			key.incrRefCount()
+			expireIfNeeded(c.db, key)
+			key.decrRefCount()
+			continue
+		}
+		c.addReplyBulkObj(key)
+		return
+	}
+}
+
+// DBSIZE
+func DbSize(c *Client, cmd *Cmd) {
+	c.addReplyInt(int64(c.db.dict.Len()))
+}
+
+// SELECT index
+func Select(c *Client, cmd *Cmd) {
+	id, ok := getInt64OrReply(c, c.args[1], "ERR invalid DB index")
+	if !ok {
+		return
+	}
+	if !selectDb(c, id) {
+		c.addReplyError(errDbIndexOutOfRange)
+		return
+	}
+	c.addReplyStatus("OK")
+}
+
+// MOVE key db，保留过期时间
+func Move(c *Client, cmd *Cmd) {
+	key := c.args[1]
+	id, ok := getInt64OrReply(c, c.args[2], "")
+	if !ok {
+		return
+	}
+	if id < 0 || id >= int64(len(server.dbs)) {
+		c.addReplyError(errDbIndexOutOfRange)
+		return
+	}
+	dstDb := server.dbs[id]
+	if dstDb == c.db {
+		c.addReplyError("ERR source and destination objects are the same")
+		return
+	}
+	obj := lookupKeyWrite(c.db, key)
+	if obj == nil || lookupKeyWrite(dstDb, key) != nil {
+		c.addReplyInt(0)
+		return
+	}
+	expire := getExpire(c.db, key)
+	obj.incrRefCount()
+	key.incrRefCount()
+	dbDelete(c.db, key)
+	dbAdd(dstDb, key, obj)
+	if expire != -1 {
+		setExpire(dstDb, key, expire)
+	}
+	key.decrRefCount()
+	obj.decrRefCount()
+	c.addReplyInt(1)
+}
+
+// SWAPDB index1 index2
+func SwapDb(c *Client, cmd *Cmd) {
+	id1, ok := getInt64OrReply(c, c.args[1], "ERR invalid first DB index")
+	if !ok {
+		return
+	}
+	id2, ok := getInt64OrReply(c, c.args[2], "ERR invalid second DB index")
+	if !ok {
+		return
+	}
+	n := int64(len(server.dbs))
+	if id1 < 0 || id1 >= n || id2 < 0 || id2 >= n {
+		c.addReplyError(errDbIndexOutOfRange)
+		return
+	}
+	if id1 != id2 {
+		swapDb(int(id1), int(id2))
+	}
+	c.addReplyStatus("OK")
+}
+
+// 解析 FLUSHDB/FLUSHALL 的 ASYNC|SYNC 参数，不指定时由 lazyfree-lazy-user-flush 决定
+func getFlushAsyncOrReply(c *Client) (bool, bool) {
+	if len(c.args) == 1 {
+		return server.lazyfreeLazyFlush, true
+	}
+	if len(c.args) == 2 {
+		switch strings.ToUpper(c.args[1].ToStr()) {
+		case "ASYNC":
+			return true, true
+		case "SYNC":
+			return false, true
+		}
+	}
+	c.addReplyError(respSyntaxErr)
+	return false, false
+}
+
+// FLUSHDB [ASYNC|SYNC]
+func FlushDb(c *Client, cmd *Cmd) {
+	async, ok := getFlushAsyncOrReply(c)
+	if !ok {
+		return
+	}
+	emptyDb(c.db, async)
+	c.addReplyStatus("OK")
+}
+
+// FLUSHALL [ASYNC|SYNC]
+func FlushAll(c *Client, cmd *Cmd) {
+	async, ok := getFlushAsyncOrReply(c)
+	if !ok {
+		return
+	}
+	for _, db := range server.dbs {
+		emptyDb(db, async)
+	}
+	c.addReplyStatus("OK")
+}
@@ -0,0 +1,558 @@
+package main
+
+import "strings"
+
+/*
+   list 类型命令
+*/
+
+type listWhere int
+
+const (
+	listHead listWhere = 0
+	listTail listWhere = 1
+)
+
+func listTypePush(obj *Obj, val *Obj, where listWhere) {
+	dq := obj.ptr.(*Deque)
+	if where == listHead {
+		dq.PushFront(val)
+	} else {
+		dq.PushBack(val)
+	}
+}
+
+// 弹出的元素所有权交给调用方，用完需 decrRefCount
+func listTypePop(obj *Obj, where listWhere) *Obj {
+	dq := obj.ptr.(*Deque)
+	if where == listHead {
+		return dq.PopFront()
+	}
+	return dq.PopBack()
+}
+
+func listTypeLen(obj *Obj) int {
+	return obj.ptr.(*Deque).Len()
+}
+
+// LEFT|RIGHT -> listWhere
+func parseListWhere(obj *Obj) (listWhere, bool) {
+	switch strings.ToUpper(obj.ToStr()) {
+	case "LEFT":
+		return listHead, true
+	case "RIGHT":
+		return listTail, true
+	}
+	return listHead, false
+}
+
+// listTypeDup 复制list，元素对象不可变，和原list共享
+func listTypeDup(obj *Obj) *Obj {
+	dq := obj.ptr.(*Deque)
+	dup := createListObject()
+	dupDq := dup.ptr.(*Deque)
+	dq.Range(0, dq.Len()-1, func(i int, val *Obj) bool {
+		dupDq.PushBack(val)
+		return true
+	})
+	return dup
+}
+
+// 负数下标转换为正向下标
+func normalizeIndex(idx int64, length int) int {
+	if idx < 0 {
+		idx += int64(length)
+	}
+	if idx < 0 || idx >= int64(length) {
+		return -1
+	}
+	return int(idx)
+}
+
+// 将 [start, end] 转换到 [0, length) 范围内，区间为空时返回 ok=false
+func normalizeRange(start, end int64, length int) (int, int, bool) {
+	l := int64(length)
+	if start < 0 {
+		start += l
+	}
+	if end < 0 {
+		end += l
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > end || start >= l {
+		return 0, 0, false
+	}
+	if end >= l {
+		end = l - 1
+	}
+	return int(start), int(end), true
+}
+
+func pushGeneric(c *Client, where listWhere) {
+	key := c.args[1]
+	obj := lookupKeyWrite(c.db, key)
+	if obj != nil && !checkType(c, obj, GType_List) {
+		return
+	}
+	if obj == nil {
+		obj = createListObject()
+		dbAdd(c.db, key, obj)
+		obj.decrRefCount()
+	}
+	for i := 2; i < len(c.args); i++ {
+		c.args[i] = tryObjectEncoding(c.args[i])
+		listTypePush(obj, c.args[i], where)
+	}
+	c.addReplyInt(int64(listTypeLen(obj)))
+}
+
+// LPUSH key element [element ...]
+func LPush(c *Client, cmd *Cmd) {
+	pushGeneric(c, listHead)
+}
+
+// RPUSH key element [element ...]
+func RPush(c *Client, cmd *Cmd) {
+	pushGeneric(c, listTail)
+}
+
+func popGeneric(c *Client, where listWhere) {
+	if len(c.args) > 3 {
+		c.addReplyErrorf(errArgsNumFmt, strings.ToLower(c.args[0].ToStr()))
+		return
+	}
+	hasCount := len(c.args) == 3
+	count := int64(1)
+	if hasCount {
+		var ok bool
+		if count, ok = getPositiveInt64OrReply(c, c.args[2], ""); !ok {
+			return
+		}
+	}
+	key := c.args[1]
+	obj := lookupKeyWrite(c.db, key)
+	if obj == nil {
+		if hasCount {
+			c.addReplyNullArray()
+		} else {
+			c.addReplyNull()
+		}
+		return
+	}
+	if !checkType(c, obj, GType_List) {
+		return
+	}
+	if !hasCount {
+		val := listTypePop(obj, where)
+		c.addReplyBulkObj(val)
+		val.decrRefCount()
+	} else {
+		if n := int64(listTypeLen(obj)); count > n {
+			count = n
+		}
+		c.addReplyArrayLen(int(count))
+		for i := int64(0); i < count; i++ {
+			val := listTypePop(obj, where)
+			c.addReplyBulkObj(val)
+			val.decrRefCount()
+		}
+	}
+	if listTypeLen(obj) == 0 {
+		dbDelete(c.db, key)
+	}
+}
+
+// LPOP key [count]
+func LPop(c *Client, cmd *Cmd) {
+	popGeneric(c, listHead)
+}
+
+// RPOP key [count]
+func RPop(c *Client, cmd *Cmd) {
+	popGeneric(c, listTail)
+}
+
+// LRANGE key start stop
+func LRange(c *Client, cmd *Cmd) {
+	start, ok := getInt64OrReply(c, c.args[2], "")
+	if !ok {
+		return
+	}
+	end, ok := getInt64OrReply(c, c.args[3], "")
+	if !ok {
+		return
+	}
+	obj := lookupKeyRead(c.db, c.args[1])
+	if obj == nil {
+		c.addReplyArrayLen(0)
+		return
+	}
+	if !checkType(c, obj, GType_List) {
+		return
+	}
+	dq := obj.ptr.(*Deque)
+	st, ed, ok := normalizeRange(start, end, dq.Len())
+	if !ok {
+		c.addReplyArrayLen(0)
+		return
+	}
+	c.addReplyArrayLen(ed - st + 1)
+	dq.Range(st, ed, func(i int, val *Obj) bool {
+		c.addReplyBulkObj(val)
+		return true
+	})
+}
+
+// LINDEX key index
+func LIndex(c *Client, cmd *Cmd) {
+	index, ok := getInt64OrReply(c, c.args[2], "")
+	if !ok {
+		return
+	}
+	obj := lookupKeyRead(c.db, c.args[1])
+	if obj == nil {
+		c.addReplyNull()
+		return
+	}
+	if !checkType(c, obj, GType_List) {
+		return
+	}
+	dq := obj.ptr.(*Deque)
+	if i := normalizeIndex(index, dq.Len()); i >= 0 {
+		c.addReplyBulkObj(dq.Index(i))
+	} else {
+		c.addReplyNull()
+	}
+}
+
+// LLEN key
+func LLen(c *Client, cmd *Cmd) {
+	obj := lookupKeyRead(c.db, c.args[1])
+	if obj == nil {
+		c.addReplyInt(0)
+		return
+	}
+	if !checkType(c, obj, GType_List) {
+		return
+	}
+	c.addReplyInt(int64(listTypeLen(obj)))
+}
+
+// LREM key count element
+// count > 0 从头部开始删除，count < 0 从尾部开始删除，count = 0 删除全部
+func LRem(c *Client, cmd *Cmd) {
+	count, ok := getInt64OrReply(c, c.args[2], "")
+	if !ok {
+		return
+	}
+	key, target := c.args[1], c.args[3]
+	obj := lookupKeyWrite(c.db, key)
+	if obj == nil {
+		c.addReplyInt(0)
+		return
+	}
+	if !checkType(c, obj, GType_List) {
+		return
+	}
+	fromTail := count < 0
+	if fromTail {
+		count = -count
+	}
+	removed := obj.ptr.(*Deque).RemoveIf(fromTail, int(count), func(val *Obj) bool {
+		return Equal(val, target)
+	})
+	if listTypeLen(obj) == 0 {
+		dbDelete(c.db, key)
+	}
+	c.addReplyInt(int64(removed))
+}
+
+// LTRIM key start stop
+func LTrim(c *Client, cmd *Cmd) {
+	start, ok := getInt64OrReply(c, c.args[2], "")
+	if !ok {
+		return
+	}
+	end, ok := getInt64OrReply(c, c.args[3], "")
+	if !ok {
+		return
+	}
+	key := c.args[1]
+	obj := lookupKeyWrite(c.db, key)
+	if obj == nil {
+		c.addReplyRaw(respOK)
+		return
+	}
+	if !checkType(c, obj, GType_List) {
+		return
+	}
+	dq := obj.ptr.(*Deque)
+	if st, ed, ok := normalizeRange(start, end, dq.Len()); ok {
+		dq.Trim(st, ed)
+	} else {
+		dq.Trim(1, 0)
+	}
+	if dq.Len() == 0 {
+		dbDelete(c.db, key)
+	}
+	c.addReplyRaw(respOK)
+}
+
+// LSET key index element
+func LSet(c *Client, cmd *Cmd) {
+	index, ok := getInt64OrReply(c, c.args[2], "")
+	if !ok {
+		return
+	}
+	obj := lookupKeyWrite(c.db, c.args[1])
+	if obj == nil {
+		c.addReplyError("ERR no such key")
+		return
+	}
+	if !checkType(c, obj, GType_List) {
+		return
+	}
+	dq := obj.ptr.(*Deque)
+	i := normalizeIndex(index, dq.Len())
+	if i < 0 {
+		c.addReplyError("ERR index out of range")
+		return
+	}
+	c.args[3] = tryObjectEncoding(c.args[3])
+	dq.Set(i, c.args[3])
+	c.addReplyRaw(respOK)
+}
+
+// LINSERT key BEFORE|AFTER pivot element
+func LInsert(c *Client, cmd *Cmd) {
+	after := false
+	switch strings.ToUpper(c.args[2].ToStr()) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		c.addReplyError(respSyntaxErr)
+		return
+	}
+	obj := lookupKeyWrite(c.db, c.args[1])
+	if obj == nil {
+		c.addReplyInt(0)
+		return
+	}
+	if !checkType(c, obj, GType_List) {
+		return
+	}
+	dq := obj.ptr.(*Deque)
+	pivot, pos := c.args[3], -1
+	dq.Range(0, dq.Len()-1, func(i int, val *Obj) bool {
+		if Equal(val, pivot) {
+			pos = i
+			return false
+		}
+		return true
+	})
+	if pos < 0 {
+		c.addReplyInt(-1)
+		return
+	}
+	if after {
+		pos++
+	}
+	c.args[4] = tryObjectEncoding(c.args[4])
+	dq.Insert(pos, c.args[4])
+	c.addReplyInt(int64(dq.Len()))
+}
+
+// lmoveGeneric 从 src 弹出元素推入 dst，返回被移动的元素，src 不存在时返回 nil
+// 调用方需保证 src/dst 类型正确
+func lmoveGeneric(c *Client, srcKey, dstKey *Obj, src *Obj, from, to listWhere) *Obj {
+	val := listTypePop(src, from)
+	dst := lookupKeyWrite(c.db, dstKey)
+	if dst == nil {
+		dst = createListObject()
+		dbAdd(c.db, dstKey, dst)
+		dst.decrRefCount()
+	}
+	listTypePush(dst, val, to)
+	val.decrRefCount()
+	// src == dst 时元素已重新推入，不会为空
+	if listTypeLen(src) == 0 {
+		dbDelete(c.db, srcKey)
+	}
+	return val
+}
+
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func LMove(c *Client, cmd *Cmd) {
+	from, ok1 := parseListWhere(c.args[3])
+	to, ok2 := parseListWhere(c.args[4])
+	if !ok1 || !ok2 {
+		c.addReplyError(respSyntaxErr)
+		return
+	}
+	srcKey, dstKey := c.args[1], c.args[2]
+	src := lookupKeyWrite(c.db, srcKey)
+	if src == nil {
+		c.addReplyNull()
+		return
+	}
+	if !checkType(c, src, GType_List) {
+		return
+	}
+	if dst := lookupKeyWrite(c.db, dstKey); dst != nil && !checkType(c, dst, GType_List) {
+		return
+	}
+	val := lmoveGeneric(c, srcKey, dstKey, src, from, to)
+	c.addReplyBulkObj(val)
+}
+
+// 从非空list弹出并回复，count 为 0 时回复 [key, val]，否则回复 [key, [val ...]]
+func listPopAndReply(c *Client, key, obj *Obj, where listWhere, count int64) {
+	c.addReplyArrayLen(2)
+	c.addReplyBulkObj(key)
+	if count == 0 {
+		val := listTypePop(obj, where)
+		c.addReplyBulkObj(val)
+		val.decrRefCount()
+	} else {
+		if n := int64(listTypeLen(obj)); count > n {
+			count = n
+		}
+		c.addReplyArrayLen(int(count))
+		for i := int64(0); i < count; i++ {
+			val := listTypePop(obj, where)
+			c.addReplyBulkObj(val)
+			val.decrRefCount()
+		}
+	}
+	if listTypeLen(obj) == 0 {
+		dbDelete(c.db, key)
+	}
+}
+
+// 阻塞client被唤醒时调用，返回是否服务成功 (已回复，可以解除阻塞)
+func serveClientBlockedOnList(c *Client, key, obj *Obj) bool {
+	if c.bstate.target != nil {
+		// 目标类型不对时和 redis 一样回复错误并解除阻塞，源list不变
+		if dst := lookupKeyWrite(c.db, c.bstate.target); dst != nil && dst.gType != GType_List {
+			c.addReplyError(respWrongType)
+			return true
+		}
+		val := lmoveGeneric(c, key, c.bstate.target, obj, c.bstate.wherefrom, c.bstate.whereto)
+		c.addReplyBulkObj(val)
+		return true
+	}
+	listPopAndReply(c, key, obj, c.bstate.wherefrom, c.bstate.count)
+	return true
+}
+
+func blockingPopGeneric(c *Client, keys []*Obj, where listWhere, count int64, timeout int64) {
+	for _, key := range keys {
+		obj := lookupKeyWrite(c.db, key)
+		if obj == nil {
+			continue
+		}
+		if !checkType(c, obj, GType_List) {
+			return
+		}
+		listPopAndReply(c, key, obj, where, count)
+		return
+	}
+	c.bstate.wherefrom = where
+	c.bstate.count = count
+	blockForKeys(c, blockType_List, keys, timeout)
+}
+
+// BLPOP key [key ...] timeout
+func BLPop(c *Client, cmd *Cmd) {
+	timeout, ok := getTimeoutOrReply(c, c.args[len(c.args)-1])
+	if !ok {
+		return
+	}
+	blockingPopGeneric(c, c.args[1:len(c.args)-1], listHead, 0, timeout)
+}
+
+// BRPOP key [key ...] timeout
+func BRPop(c *Client, cmd *Cmd) {
+	timeout, ok := getTimeoutOrReply(c, c.args[len(c.args)-1])
+	if !ok {
+		return
+	}
+	blockingPopGeneric(c, c.args[1:len(c.args)-1], listTail, 0, timeout)
+}
+
+// BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func BLMPop(c *Client, cmd *Cmd) {
+	timeout, ok := getTimeoutOrReply(c, c.args[1])
+	if !ok {
+		return
+	}
+	numKeys, ok := getInt64OrReply(c, c.args[2], "")
+	if !ok {
+		return
+	}
+	if numKeys <= 0 {
+		c.addReplyError("ERR numkeys should be greater than 0")
+		return
+	}
+	whereIdx := 3 + numKeys
+	if whereIdx >= int64(len(c.args)) {
+		c.addReplyError(respSyntaxErr)
+		return
+	}
+	where, ok := parseListWhere(c.args[whereIdx])
+	if !ok {
+		c.addReplyError(respSyntaxErr)
+		return
+	}
+	count := int64(1)
+	for i := whereIdx + 1; i < int64(len(c.args)); i++ {
+		if strings.ToUpper(c.args[i].ToStr()) == "COUNT" && i+1 < int64(len(c.args)) {
+			if count, ok = getInt64OrReply(c, c.args[i+1], ""); !ok {
+				return
+			}
+			if count <= 0 {
+				c.addReplyError("ERR count should be greater than 0")
+				return
+			}
+			i++
+		} else {
+			c.addReplyError(respSyntaxErr)
+			return
+		}
+	}
+	blockingPopGeneric(c, c.args[3:whereIdx], where, count, timeout)
+}
+
+// BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func BLMove(c *Client, cmd *Cmd) {
+	from, ok1 := parseListWhere(c.args[3])
+	to, ok2 := parseListWhere(c.args[4])
+	if !ok1 || !ok2 {
+		c.addReplyError(respSyntaxErr)
+		return
+	}
+	timeout, ok := getTimeoutOrReply(c, c.args[5])
+	if !ok {
+		return
+	}
+	srcKey, dstKey := c.args[1], c.args[2]
+	src := lookupKeyWrite(c.db, srcKey)
+	if src != nil {
+		if !checkType(c, src, GType_List) {
+			return
+		}
+		if dst := lookupKeyWrite(c.db, dstKey); dst != nil && !checkType(c, dst, GType_List) {
+			return
+		}
+		val := lmoveGeneric(c, srcKey, dstKey, src, from, to)
+		c.addReplyBulkObj(val)
+		return
+	}
+	c.bstate.wherefrom = from
+	c.bstate.whereto = to
+	c.bstate.target = dstKey
+	blockForKeys(c, blockType_List, []*Obj{srcKey}, timeout)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+   MEMORY 命令，内存估算见 memory.go
+*/
+
+// memoryDoctorMinMemory 内存太小时诊断没有意义
+const memoryDoctorMinMemory = 5 << 20
+
+// MEMORY USAGE key [SAMPLES count] | STATS | DOCTOR | HELP
+func Memory(c *Client, cmd *Cmd) {
+	sub := strings.ToUpper(c.args[1].ToStr())
+	switch {
+	case sub == "USAGE" && len(c.args) >= 3:
+		memoryUsage(c)
+	case sub == "STATS" && len(c.args) == 2:
+		memoryStats(c)
+	case sub == "DOCTOR" && len(c.args) == 2:
+		c.addReplyBulk(memoryDoctor())
+	case sub == "HELP" && len(c.args) == 2:
+		help := []string{
+			"MEMORY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"DOCTOR",
+			"    Return memory problems reports.",
+			"STATS",
+			"    Return information about the memory usage of the server.",
+			"USAGE <key> [SAMPLES <count>]",
+			"    Return memory in bytes used by <key> and its value. Nested values are",
+			"    sampled up to <count> times (default: 5, 0 means sample all).",
+		}
+		c.addReplyArrayLen(len(help))
+		for _, line := range help {
+			c.addReplyStatus(line)
+		}
+	default:
+		c.addReplyErrorf("ERR unknown subcommand or wrong number of arguments for '%s'", c.args[1].ToStr())
+	}
+}
+
+func memoryUsage(c *Client) {
+	samples := int64(memoryUsageDefaultSamples)
+	for i := 3; i < len(c.args); i++ {
+		if strings.ToUpper(c.args[i].ToStr()) == "SAMPLES" && i+1 < len(c.args) {
+			var ok bool
+			if samples, ok = getInt64OrReply(c, c.args[i+1], ""); !ok {
+				return
+			}
+			if samples < 0 {
+				c.addReplyError(respSyntaxErr)
+				return
+			}
+			i++
+		} else {
+			c.addReplyError(respSyntaxErr)
+			return
+		}
+	}
+	key := c.args[2]
+	val := lookupKeyRead(c.db, key)
+	if val == nil {
+		c.addReplyNull()
+		return
+	}
+	size := objectComputeSize(val, int(samples)) + keyMemory(key) + hEntrySize
+	c.addReplyInt(size)
+}
+
+func memoryStats(c *Client) {
+	used := usedMemory()
+	dataset := datasetMemory()
+	keys := 0
+	type dbStat struct {
+		id               int
+		main, expireSize int64
+	}
+	var dbStats []dbStat
+	for _, db := range server.dbs {
+		keys += db.dict.Len()
+		if db.dict.Len() == 0 {
+			continue
+		}
+		main, expires := dbOverheadMemory(db)
+		dbStats = append(dbStats, dbStat{id: db.id, main: main, expireSize: expires})
+	}
+	bytesPerKey := int64(0)
+	if keys > 0 {
+		bytesPerKey = used / int64(keys)
+	}
+	datasetPerc := 0.0
+	if used > 0 {
+		datasetPerc = float64(dataset) * 100 / float64(used)
+	}
+
+	// 先收集字段再回复，数组长度由字段个数决定
+	type statField struct {
+		name  string
+		reply func()
+	}
+	intField := func(name string, v int64) statField {
+		return statField{name: name, reply: func() { c.addReplyInt(v) }}
+	}
+	fields := []statField{
+		intField("peak.allocated", server.statPeakMemory),
+		intField("total.allocated", used),
+		intField("overhead.total", used-dataset),
+		intField("clients.normal", clientsMemory()),
+	}
+	for _, st := range dbStats {
+		st := st
+		fields = append(fields, statField{name: fmt.Sprintf("db.%d", st.id), reply: func() {
+			c.addReplyArrayLen(4)
+			c.addReplyBulk("overhead.hashtable.main")
+			c.addReplyInt(st.main)
+			c.addReplyBulk("overhead.hashtable.expires")
+			c.addReplyInt(st.expireSize)
+		}})
+	}
+	fields = append(fields,
+		intField("keys.count", int64(keys)),
+		intField("keys.bytes-per-key", bytesPerKey),
+		intField("dataset.bytes", dataset),
+		statField{name: "dataset.percentage", reply: func() { c.addReplyBulk(fmt.Sprintf("%.2f", datasetPerc)) }},
+	)
+
+	c.addReplyArrayLen(len(fields) * 2)
+	for _, f := range fields {
+		c.addReplyBulk(f.name)
+		f.reply()
+	}
+}
+
+// memoryDoctor 根据内存统计给出可能的问题
+func memoryDoctor() string {
+	used := usedMemory()
+	if used < memoryDoctorMinMemory {
+		return "Hi Sam, this instance is empty or is using very little memory, " +
+			"my issues detector can't be used in these conditions. " +
+			"Please, leave for your mission on Earth and fill it with some data."
+	}
+	var issues []string
+	// 峰值超过当前的 150%
+	if server.statPeakMemory > used*3/2 {
+		issues = append(issues, " * Peak memory: In the past this instance used more than 150% the memory "+
+			"that is currently using. Freed memory is returned to the Go runtime and reused as soon as "+
+			"the instance is filled with more data, so the process RSS may stay above used_memory.")
+	}
+	// 平均每个client的缓冲超过 200KB
+	if n := int64(len(server.clients)); n > 0 && clientsMemory()/n > 200<<10 {
+		issues = append(issues, " * Big client buffers: The clients output buffers are in the average "+
+			"bigger than 200 KB. Clients reading big replies slowly or issuing huge pipelines may cause this.")
+	}
+	if maxmemory := server.maxmemory; maxmemory > 0 && server.maxmemoryPolicy == maxmemory_NoEviction &&
+		used > maxmemory*9/10 {
+		issues = append(issues, " * Maxmemory: used memory is above 90% of maxmemory and the policy is "+
+			"noeviction, write commands will soon be rejected with -OOM.")
+	}
+	if len(issues) == 0 {
+		return "Hi Sam, I can't find any memory issue in your instance. " +
+			"I can only account for what occurs on this base."
+	}
+	return "Sam, I detected a few issues in this instance memory implants:\n\n" +
+		strings.Join(issues, "\n\n") +
+		"\n\nI'm here to keep you safe, Sam. I want to help you.\n"
+}
@@ -0,0 +1,601 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+/*
+   set 类型命令
+   元素全是整数且个数较少时使用 intset 编码，否则使用 Dict (只用key)
+*/
+
+const setMaxIntsetEntries = 512 // intset 编码最多的元素个数
+
+// srandmemberSubStrategyMul SRANDMEMBER 的 count 乘以该值仍超过集合大小时复制全部元素挑选，否则随机抽样
+const srandmemberSubStrategyMul = 3
+
+// 根据第一个元素选择编码
+func setTypeCreate(member *Obj) *Obj {
+	if _, ok := string2Int64(member.ToStr()); ok {
+		return createIntsetObject()
+	}
+	return createSetObject()
+}
+
+func setTypeSize(obj *Obj) int {
+	if obj.encoding == GEncoding_Intset {
+		return obj.ptr.(*Intset).Len()
+	}
+	return obj.ptr.(*Dict).Len()
+}
+
+// intset 转为 Dict 编码
+func setTypeConvert(obj *Obj) {
+	if obj.encoding != GEncoding_Intset {
+		return
+	}
+	is := obj.ptr.(*Intset)
+	d := NewDict(StrDictType)
+	for _, v := range is.contents {
+		member := NewObjectFromInt64(v)
+		_ = d.Add(member, nil)
+		member.decrRefCount()
+	}
+	obj.ptr = d
+	obj.encoding = GEncoding_Hashtable
+}
+
+// setTypeAdd 添加元素，已存在返回 false
+func setTypeAdd(obj *Obj, member *Obj) bool {
+	if obj.encoding == GEncoding_Intset {
+		if v, ok := string2Int64(member.ToStr()); ok {
+			if !obj.ptr.(*Intset).Add(v) {
+				return false
+			}
+			if setTypeSize(obj) > setMaxIntsetEntries {
+				setTypeConvert(obj)
+			}
+			return true
+		}
+		setTypeConvert(obj)
+	}
+	return obj.ptr.(*Dict).Add(member, nil) == nil
+}
+
+func setTypeRemove(obj *Obj, member *Obj) bool {
+	if obj.encoding == GEncoding_Intset {
+		if v, ok := string2Int64(member.ToStr()); ok {
+			return obj.ptr.(*Intset).Remove(v)
+		}
+		return false
+	}
+	return obj.ptr.(*Dict).Del(member)
+}
+
+func setTypeIsMember(obj *Obj, member *Obj) bool {
+	if obj.encoding == GEncoding_Intset {
+		if v, ok := string2Int64(member.ToStr()); ok {
+			return obj.ptr.(*Intset).Find(v)
+		}
+		return false
+	}
+	return obj.ptr.(*Dict).Exists(member)
+}
+
+// setTypeRange 遍历元素，fn 返回 false 时停止
+func setTypeRange(obj *Obj, fn func(member *Obj) bool) {
+	if obj.encoding == GEncoding_Intset {
+		for _, v := range obj.ptr.(*Intset).contents {
+			if !fn(NewObjectFromInt64(v)) {
+				return
+			}
+		}
+		return
+	}
+	obj.ptr.(*Dict).Range(func(key, val *Obj) bool {
+		return fn(key)
+	})
+}
+
+func setTypeRandom(obj *Obj) *Obj {
+	if obj.encoding == GEncoding_Intset {
+		return NewObjectFromInt64(obj.ptr.(*Intset).Random())
+	}
+	return obj.ptr.(*Dict).FairRandomGet().key
+}
+
+// setTypeDup 复制set，保持原编码
+func setTypeDup(obj *Obj) *Obj {
+	if obj.encoding == GEncoding_Intset {
+		dup := createIntsetObject()
+		is := obj.ptr.(*Intset)
+		dup.ptr.(*Intset).contents = append([]int64(nil), is.contents...)
+		return dup
+	}
+	dup := createSetObject()
+	d := dup.ptr.(*Dict)
+	setTypeRange(obj, func(member *Obj) bool {
+		_ = d.Add(member, nil)
+		return true
+	})
+	return dup
+}
+
+// 读取set，key不存在返回nil，类型不对时回复错误并返回 ok=false
+func lookupSetRead(c *Client, key *Obj) (*Obj, bool) {
+	obj := lookupKeyRead(c.db, key)
+	if obj == nil {
+		return nil, true
+	}
+	if !checkType(c, obj, Gtype_Set) {
+		return nil, false
+	}
+	return obj, true
+}
+
+// 写set，key不存在返回nil，类型不对时回复错误并返回 ok=false
+func lookupSetWrite(c *Client, key *Obj) (*Obj, bool) {
+	obj := lookupKeyWrite(c.db, key)
+	if obj == nil {
+		return nil, true
+	}
+	if !checkType(c, obj, Gtype_Set) {
+		return nil, false
+	}
+	return obj, true
+}
+
+// SADD key member [member ...]
+func SAdd(c *Client, cmd *Cmd) {
+	key := c.args[1]
+	obj, ok := lookupSetWrite(c, key)
+	if !ok {
+		return
+	}
+	if obj == nil {
+		obj = setTypeCreate(c.args[2])
+		dbAdd(c.db, key, obj)
+		obj.decrRefCount()
+	}
+	added := 0
+	for i := 2; i < len(c.args); i++ {
+		c.args[i] = tryObjectEncoding(c.args[i])
+		if setTypeAdd(obj, c.args[i]) {
+			added++
+		}
+	}
+	c.addReplyInt(int64(added))
+}
+
+// SREM key member [member ...]
+func SRem(c *Client, cmd *Cmd) {
+	key := c.args[1]
+	obj, ok := lookupSetWrite(c, key)
+	if !ok {
+		return
+	}
+	if obj == nil {
+		c.addReplyInt(0)
+		return
+	}
+	removed := 0
+	for _, member := range c.args[2:] {
+		if setTypeRemove(obj, member) {
+			removed++
+		}
+		if setTypeSize(obj) == 0 {
+			dbDelete(c.db, key)
+			break
+		}
+	}
+	c.addReplyInt(int64(removed))
+}
+
+// SISMEMBER key member
+func SIsMember(c *Client, cmd *Cmd) {
+	obj, ok := lookupSetRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if obj != nil && setTypeIsMember(obj, c.args[2]) {
+		c.addReplyInt(1)
+	} else {
+		c.addReplyInt(0)
+	}
+}
+
+// SMISMEMBER key member [member ...]
+func SMIsMember(c *Client, cmd *Cmd) {
+	obj, ok := lookupSetRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	c.addReplyArrayLen(len(c.args) - 2)
+	for _, member := range c.args[2:] {
+		if obj != nil && setTypeIsMember(obj, member) {
+			c.addReplyInt(1)
+		} else {
+			c.addReplyInt(0)
+		}
+	}
+}
+
+// SCARD key
+func SCard(c *Client, cmd *Cmd) {
+	obj, ok := lookupSetRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if obj == nil {
+		c.addReplyInt(0)
+		return
+	}
+	c.addReplyInt(int64(setTypeSize(obj)))
+}
+
+// SMEMBERS key
+func SMembers(c *Client, cmd *Cmd) {
+	obj, ok := lookupSetRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if obj == nil {
+		c.addReplyArrayLen(0)
+		return
+	}
+	c.addReplyArrayLen(setTypeSize(obj))
+	setTypeRange(obj, func(member *Obj) bool {
+		c.addReplyBulkObj(member)
+		return true
+	})
+}
+
+// SPOP key [count]
+func SPop(c *Client, cmd *Cmd) {
+	if len(c.args) > 3 {
+		c.addReplyError(respSyntaxErr)
+		return
+	}
+	hasCount := len(c.args) == 3
+	count := int64(1)
+	if hasCount {
+		var ok bool
+		if count, ok = getPositiveInt64OrReply(c, c.args[2], ""); !ok {
+			return
+		}
+	}
+	key := c.args[1]
+	obj, ok := lookupSetWrite(c, key)
+	if !ok {
+		return
+	}
+	if obj == nil {
+		if hasCount {
+			c.addReplyArrayLen(0)
+		} else {
+			c.addReplyNull()
+		}
+		return
+	}
+	if size := int64(setTypeSize(obj)); count > size {
+		count = size
+	}
+	if hasCount {
+		c.addReplyArrayLen(int(count))
+	}
+	for i := int64(0); i < count; i++ {
+		member := setTypeRandom(obj)
+		member.incrRefCount() // 删除后仍需回复
+		setTypeRemove(obj, member)
+		c.addReplyBulkObj(member)
+		member.decrRefCount()
+	}
+	if setTypeSize(obj) == 0 {
+		dbDelete(c.db, key)
+	}
+}
+
+// SRANDMEMBER key [count]
+// count > 0 返回不重复的元素，count < 0 元素可重复
+func SRandMember(c *Client, cmd *Cmd) {
+	if len(c.args) > 3 {
+		c.addReplyError(respSyntaxErr)
+		return
+	}
+	obj, ok := lookupSetRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if len(c.args) == 2 {
+		if obj == nil {
+			c.addReplyNull()
+			return
+		}
+		c.addReplyBulkObj(setTypeRandom(obj))
+		return
+	}
+	// 和 redis 一样限制范围，避免 -count 溢出
+	count, ok := getRangeInt64OrReply(c, c.args[2], -math.MaxInt64/2, math.MaxInt64/2, "")
+	if !ok {
+		return
+	}
+	if obj == nil || count == 0 {
+		c.addReplyArrayLen(0)
+		return
+	}
+	if count < 0 {
+		c.addReplyArrayLen(int(-count))
+		for i := int64(0); i < -count; i++ {
+			c.addReplyBulkObj(setTypeRandom(obj))
+		}
+		return
+	}
+	size := int64(setTypeSize(obj))
+	members := make([]*Obj, 0, count)
+	switch {
+	case count >= size:
+		// 返回全部元素
+		setTypeRange(obj, func(member *Obj) bool {
+			members = append(members, member)
+			return true
+		})
+	case count*srandmemberSubStrategyMul > size:
+		// count 接近集合大小，复制全部元素后随机挑选
+		all := make([]*Obj, 0, size)
+		setTypeRange(obj, func(member *Obj) bool {
+			all = append(all, member)
+			return true
+		})
+		for i := 0; i < int(count); i++ {
+			j := i + rand.Intn(len(all)-i)
+			all[i], all[j] = all[j], all[i]
+		}
+		members = all[:count]
+	default:
+		// count 远小于集合大小，随机抽样去重，不用复制整个集合
+		picked := make(map[string]struct{}, count)
+		for int64(len(members)) < count {
+			member := setTypeRandom(obj)
+			if _, ok := picked[member.ToStr()]; ok {
+				continue
+			}
+			picked[member.ToStr()] = struct{}{}
+			members = append(members, member)
+		}
+	}
+	c.addReplyArrayLen(len(members))
+	for _, member := range members {
+		c.addReplyBulkObj(member)
+	}
+}
+
+// SMOVE source destination member
+func SMove(c *Client, cmd *Cmd) {
+	srcKey, dstKey, member := c.args[1], c.args[2], c.args[3]
+	src, ok := lookupSetWrite(c, srcKey)
+	if !ok {
+		return
+	}
+	dst, ok := lookupSetWrite(c, dstKey)
+	if !ok {
+		return
+	}
+	if src == nil || !setTypeIsMember(src, member) {
+		c.addReplyInt(0)
+		return
+	}
+	if src == dst {
+		c.addReplyInt(1)
+		return
+	}
+	setTypeRemove(src, member)
+	if setTypeSize(src) == 0 {
+		dbDelete(c.db, srcKey)
+	}
+	if dst == nil {
+		dst = setTypeCreate(member)
+		dbAdd(c.db, dstKey, dst)
+		dst.decrRefCount()
+	}
+	c.args[3] = tryObjectEncoding(member)
+	setTypeAdd(dst, c.args[3])
+	c.addReplyInt(1)
+}
+
+const (
+	setOpUnion = 0
+	setOpDiff  = 1
+	setOpInter = 2
+)
+
+// 读取多个set，不存在的key为nil，类型不对时回复错误
+func lookupSets(c *Client, keys []*Obj) ([]*Obj, bool) {
+	sets := make([]*Obj, len(keys))
+	for i, key := range keys {
+		obj, ok := lookupSetRead(c, key)
+		if !ok {
+			return nil, false
+		}
+		sets[i] = obj
+	}
+	return sets, true
+}
+
+// 计算交集，limit > 0 时找到 limit 个后停止
+func setInter(sets []*Obj, limit int) []*Obj {
+	result := make([]*Obj, 0)
+	for _, set := range sets {
+		if set == nil {
+			return result
+		}
+	}
+	// 从最小的集合开始遍历
+	sorted := append([]*Obj(nil), sets...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return setTypeSize(sorted[i]) < setTypeSize(sorted[j])
+	})
+	setTypeRange(sorted[0], func(member *Obj) bool {
+		for _, other := range sorted[1:] {
+			if other != sorted[0] && !setTypeIsMember(other, member) {
+				return true
+			}
+		}
+		result = append(result, member)
+		return limit <= 0 || len(result) < limit
+	})
+	return result
+}
+
+// 并集和差集，结果放在新的 set 对象中
+func setUnionDiff(sets []*Obj, op int) *Obj {
+	var result *Obj
+	add := func(member *Obj) {
+		if result == nil {
+			result = setTypeCreate(member)
+		}
+		setTypeAdd(result, member)
+	}
+	if op == setOpUnion {
+		for _, set := range sets {
+			if set == nil {
+				continue
+			}
+			setTypeRange(set, func(member *Obj) bool {
+				add(member)
+				return true
+			})
+		}
+		return result
+	}
+	if sets[0] == nil {
+		return nil
+	}
+	setTypeRange(sets[0], func(member *Obj) bool {
+		for _, other := range sets[1:] {
+			if other != nil && (other == sets[0] || setTypeIsMember(other, member)) {
+				return true
+			}
+		}
+		add(member)
+		return true
+	})
+	return result
+}
+
+// sinter/sunion/sdiff 及其 STORE 版本，dstKey 为 nil 时直接回复结果
+func setOpGeneric(c *Client, keys []*Obj, dstKey *Obj, op int) {
+	sets, ok := lookupSets(c, keys)
+	if !ok {
+		return
+	}
+	var result *Obj
+	if op == setOpInter {
+		for _, member := range setInter(sets, 0) {
+			if result == nil {
+				result = setTypeCreate(member)
+			}
+			setTypeAdd(result, member)
+		}
+	} else {
+		result = setUnionDiff(sets, op)
+	}
+
+	if dstKey == nil {
+		if result == nil {
+			c.addReplyArrayLen(0)
+			return
+		}
+		c.addReplyArrayLen(setTypeSize(result))
+		setTypeRange(result, func(member *Obj) bool {
+			c.addReplyBulkObj(member)
+			return true
+		})
+		return
+	}
+	if result == nil || setTypeSize(result) == 0 {
+		dbDelete(c.db, dstKey)
+		c.addReplyInt(0)
+		return
+	}
+	setKey(c.db, dstKey, result)
+	result.decrRefCount()
+	c.addReplyInt(int64(setTypeSize(result)))
+}
+
+// SINTER key [key ...]
+func SInter(c *Client, cmd *Cmd) {
+	setOpGeneric(c, c.args[1:], nil, setOpInter)
+}
+
+// SINTERSTORE destination key [key ...]
+func SInterStore(c *Client, cmd *Cmd) {
+	setOpGeneric(c, c.args[2:], c.args[1], setOpInter)
+}
+
+// SUNION key [key ...]
+func SUnion(c *Client, cmd *Cmd) {
+	setOpGeneric(c, c.args[1:], nil, setOpUnion)
+}
+
+// SUNIONSTORE destination key [key ...]
+func SUnionStore(c *Client, cmd *Cmd) {
+	setOpGeneric(c, c.args[2:], c.args[1], setOpUnion)
+}
+
+// SDIFF key [key ...]
+func SDiff(c *Client, cmd *Cmd) {
+	setOpGeneric(c, c.args[1:], nil, setOpDiff)
+}
+
+// SDIFFSTORE destination key [key ...]
+func SDiffStore(c *Client, cmd *Cmd) {
+	setOpGeneric(c, c.args[2:], c.args[1], setOpDiff)
+}
+
+// SINTERCARD numkeys key [key ...] [LIMIT limit]
+func SInterCard(c *Client, cmd *Cmd) {
+	numKeys, ok := getInt64OrReply(c, c.args[1], "")
+	if !ok {
+		return
+	}
+	if numKeys <= 0 {
+		c.addReplyError("ERR numkeys should be greater than 0")
+		return
+	}
+	if numKeys > int64(len(c.args)-2) {
+		c.addReplyError("ERR Number of keys can't be greater than number of args")
+		return
+	}
+	limit := int64(0)
+	for i := 2 + numKeys; i < int64(len(c.args)); i += 2 {
+		if strings.ToUpper(c.args[i].ToStr()) == "LIMIT" && i+1 < int64(len(c.args)) {
+			if limit, ok = getPositiveInt64OrReply(c, c.args[i+1], "ERR LIMIT can't be negative"); !ok {
+				return
+			}
+		} else {
+			c.addReplyError(respSyntaxErr)
+			return
+		}
+	}
+	sets, ok := lookupSets(c, c.args[2:2+numKeys])
+	if !ok {
+		return
+	}
+	c.addReplyInt(int64(len(setInter(sets, int(limit)))))
+}
+
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+func SScan(c *Client, cmd *Cmd) {
+	obj, ok := lookupSetRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if obj == nil {
+		c.addReplyRaw(respEmptyScan)
+		return
+	}
+	scanGeneric(c, obj, 2)
+}
@@ -0,0 +1,1161 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+   zset 类型命令
+*/
+
+const errNotFloat = "ERR value is not a valid float"
+
+type zsetItem struct {
+	member *Obj
+	score  float64
+}
+
+func parseScoreOrReply(c *Client, obj *Obj) (float64, bool) {
+	v, err := strconv.ParseFloat(obj.ToStr(), 64)
+	if err != nil || math.IsNaN(v) {
+		c.addReplyError(errNotFloat)
+		return 0, false
+	}
+	return v, true
+}
+
+// 读取zset，key不存在返回nil，类型不对时回复错误并返回 ok=false
+func lookupZsetRead(c *Client, key *Obj) (*Obj, bool) {
+	obj := lookupKeyRead(c.db, key)
+	if obj == nil {
+		return nil, true
+	}
+	if !checkType(c, obj, GType_ZSet) {
+		return nil, false
+	}
+	return obj, true
+}
+
+// 写zset，key不存在返回nil，类型不对时回复错误并返回 ok=false
+func lookupZsetWrite(c *Client, key *Obj) (*Obj, bool) {
+	obj := lookupKeyWrite(c.db, key)
+	if obj == nil {
+		return nil, true
+	}
+	if !checkType(c, obj, GType_ZSet) {
+		return nil, false
+	}
+	return obj, true
+}
+
+func (c *Client) addReplyScore(score float64) {
+	c.addReplyBulk(formatScore(score))
+}
+
+// ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+func ZAdd(c *Client, cmd *Cmd) {
+	zaddGeneric(c, 0)
+}
+
+// ZINCRBY key increment member
+func ZIncrBy(c *Client, cmd *Cmd) {
+	zaddGeneric(c, zaddIn_Incr)
+}
+
+func zaddGeneric(c *Client, flags int) {
+	ch := false
+	idx := 2
+	for ; flags&zaddIn_Incr == 0 && idx < len(c.args); idx++ { // ZINCRBY 不支持选项
+		switch strings.ToUpper(c.args[idx].ToStr()) {
+		case "NX":
+			flags |= zaddIn_NX
+		case "XX":
+			flags |= zaddIn_XX
+		case "GT":
+			flags |= zaddIn_GT
+		case "LT":
+			flags |= zaddIn_LT
+		case "CH":
+			ch = true
+		case "INCR":
+			flags |= zaddIn_Incr
+		default:
+			goto parsed
+		}
+	}
+parsed:
+	elements := len(c.args) - idx
+	if elements == 0 || elements%2 != 0 {
+		c.addReplyError(respSyntaxErr)
+		return
+	}
+	elements /= 2
+	incr := flags&zaddIn_Incr != 0
+	if flags&zaddIn_NX != 0 && flags&zaddIn_XX != 0 {
+		c.addReplyError("ERR XX and NX options at the same time are not compatible")
+		return
+	}
+	if (flags&zaddIn_GT != 0 && flags&zaddIn_NX != 0) ||
+		(flags&zaddIn_LT != 0 && flags&zaddIn_NX != 0) ||
+		(flags&zaddIn_GT != 0 && flags&zaddIn_LT != 0) {
+		c.addReplyError("ERR GT, LT, and/or NX options at the same time are not compatible")
+		return
+	}
+	if incr && elements > 1 {
+		c.addReplyError("ERR INCR option supports a single increment-element pair")
+		return
+	}
+	scores := make([]float64, elements)
+	for i := 0; i < elements; i++ {
+		var ok bool
+		if scores[i], ok = parseScoreOrReply(c, c.args[idx+2*i]); !ok {
+			return
+		}
+	}
+
+	key := c.args[1]
+	zobj, ok := lookupZsetWrite(c, key)
+	if !ok {
+		return
+	}
+	if zobj == nil {
+		if flags&zaddIn_XX != 0 {
+			if incr {
+				c.addReplyNull()
+			} else {
+				c.addReplyInt(0)
+			}
+			return
+		}
+		zobj = createZsetListpackObject()
+		dbAdd(c.db, key, zobj)
+		zobj.decrRefCount()
+	}
+
+	added, updated, processed := 0, 0, 0
+	var score float64
+	for i := 0; i < elements; i++ {
+		member := c.args[idx+2*i+1]
+		out, newScore := zsetAdd(zobj, scores[i], member, flags)
+		if out&zaddOut_NaN != 0 {
+			c.addReplyError("ERR resulting score is not a number (NaN)")
+			if zsetLength(zobj) == 0 {
+				dbDelete(c.db, key)
+			}
+			return
+		}
+		if out&zaddOut_Added != 0 {
+			added++
+		}
+		if out&zaddOut_Updated != 0 {
+			updated++
+		}
+		if out&zaddOut_Nop == 0 {
+			processed++
+		}
+		score = newScore
+	}
+	if zsetLength(zobj) == 0 {
+		dbDelete(c.db, key)
+	}
+	if incr {
+		if processed > 0 {
+			c.addReplyScore(score)
+		} else {
+			c.addReplyNull()
+		}
+		return
+	}
+	if ch {
+		c.addReplyInt(int64(added + updated))
+	} else {
+		c.addReplyInt(int64(added))
+	}
+}
+
+// ZREM key member [member ...]
+func ZRem(c *Client, cmd *Cmd) {
+	key := c.args[1]
+	zobj, ok := lookupZsetWrite(c, key)
+	if !ok {
+		return
+	}
+	if zobj == nil {
+		c.addReplyInt(0)
+		return
+	}
+	deleted := 0
+	for _, member := range c.args[2:] {
+		if zsetDel(zobj, member) {
+			deleted++
+		}
+		if zsetLength(zobj) == 0 {
+			dbDelete(c.db, key)
+			break
+		}
+	}
+	c.addReplyInt(int64(deleted))
+}
+
+// ZSCORE key member
+func ZScore(c *Client, cmd *Cmd) {
+	zobj, ok := lookupZsetRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if zobj == nil {
+		c.addReplyNull()
+		return
+	}
+	if score, ok := zsetScore(zobj, c.args[2]); ok {
+		c.addReplyScore(score)
+	} else {
+		c.addReplyNull()
+	}
+}
+
+// ZMSCORE key member [member ...]
+func ZMScore(c *Client, cmd *Cmd) {
+	zobj, ok := lookupZsetRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	c.addReplyArrayLen(len(c.args) - 2)
+	for _, member := range c.args[2:] {
+		if zobj == nil {
+			c.addReplyNull()
+			continue
+		}
+		if score, ok := zsetScore(zobj, member); ok {
+			c.addReplyScore(score)
+		} else {
+			c.addReplyNull()
+		}
+	}
+}
+
+// ZCARD key
+func ZCard(c *Client, cmd *Cmd) {
+	zobj, ok := lookupZsetRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if zobj == nil {
+		c.addReplyInt(0)
+		return
+	}
+	c.addReplyInt(int64(zsetLength(zobj)))
+}
+
+func zrankGeneric(c *Client, reverse bool) {
+	withScore := false
+	if len(c.args) > 4 {
+		c.addReplyErrorf(errArgsNumFmt, strings.ToLower(c.args[0].ToStr()))
+		return
+	}
+	if len(c.args) == 4 {
+		if strings.ToUpper(c.args[3].ToStr()) != "WITHSCORE" {
+			c.addReplyError(respSyntaxErr)
+			return
+		}
+		withScore = true
+	}
+	zobj, ok := lookupZsetRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	var (
+		rank  int
+		score float64
+		found bool
+	)
+	if zobj != nil {
+		rank, score, found = zsetRank(zobj, c.args[2], reverse)
+	}
+	if !found {
+		if withScore {
+			c.addReplyNullArray()
+		} else {
+			c.addReplyNull()
+		}
+		return
+	}
+	if withScore {
+		c.addReplyArrayLen(2)
+		c.addReplyInt(int64(rank))
+		c.addReplyScore(score)
+	} else {
+		c.addReplyInt(int64(rank))
+	}
+}
+
+// ZRANK key member [WITHSCORE]
+func ZRank(c *Client, cmd *Cmd) {
+	zrankGeneric(c, false)
+}
+
+// ZREVRANK key member [WITHSCORE]
+func ZRevRank(c *Client, cmd *Cmd) {
+	zrankGeneric(c, true)
+}
+
+type zrangeType int
+
+const (
+	zrangeType_Rank  zrangeType = 0
+	zrangeType_Score zrangeType = 1
+	zrangeType_Lex   zrangeType = 2
+)
+
+type zrangeOpts struct {
+	rtype      zrangeType
+	reverse    bool
+	withScores bool
+	offset     int64
+	limit      int64 // -1 不限制
+}
+
+// 解析 ZRANGE 的可选参数 [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func parseZrangeOpts(c *Client, args []*Obj) (*zrangeOpts, bool) {
+	opts := &zrangeOpts{limit: -1}
+	hasLimit := false
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i].ToStr()) {
+		case "BYSCORE":
+			opts.rtype = zrangeType_Score
+		case "BYLEX":
+			opts.rtype = zrangeType_Lex
+		case "REV":
+			opts.reverse = true
+		case "WITHSCORES":
+			opts.withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				c.addReplyError(respSyntaxErr)
+				return nil, false
+			}
+			var ok bool
+			if opts.offset, ok = getInt64OrReply(c, args[i+1], ""); !ok {
+				return nil, false
+			}
+			if opts.limit, ok = getInt64OrReply(c, args[i+2], ""); !ok {
+				return nil, false
+			}
+			hasLimit = true
+			i += 2
+		default:
+			c.addReplyError(respSyntaxErr)
+			return nil, false
+		}
+	}
+	if hasLimit && opts.rtype == zrangeType_Rank {
+		c.addReplyError("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+		return nil, false
+	}
+	if opts.withScores && opts.rtype == zrangeType_Lex {
+		c.addReplyError("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+		return nil, false
+	}
+	return opts, true
+}
+
+// zrangeSelect 按 opts 选出 [start, stop] 对应的元素，按返回顺序排列
+// BYSCORE/BYLEX 且 REV 时 start 为上界 stop 为下界
+func zrangeSelect(c *Client, zobj *Obj, startObj, stopObj *Obj, opts *zrangeOpts) ([]zsetItem, bool) {
+	var lo, hi int
+	switch opts.rtype {
+	case zrangeType_Rank:
+		start, ok := getInt64OrReply(c, startObj, "")
+		if !ok {
+			return nil, false
+		}
+		end, ok := getInt64OrReply(c, stopObj, "")
+		if !ok {
+			return nil, false
+		}
+		if zobj == nil {
+			return nil, true
+		}
+		length := zsetLength(zobj)
+		st, ed, ok := normalizeRange(start, end, length)
+		if !ok {
+			return nil, true
+		}
+		lo, hi = st, ed
+		if opts.reverse { // 倒序排名转为升序排名
+			lo, hi = length-1-ed, length-1-st
+		}
+	case zrangeType_Score:
+		minObj, maxObj := startObj, stopObj
+		if opts.reverse {
+			minObj, maxObj = stopObj, startObj
+		}
+		spec, ok := parseRange(minObj, maxObj)
+		if !ok {
+			c.addReplyError("ERR min or max is not a float")
+			return nil, false
+		}
+		if zobj == nil {
+			return nil, true
+		}
+		lo, hi = zsetRankRangeByScore(zobj, spec)
+	case zrangeType_Lex:
+		minObj, maxObj := startObj, stopObj
+		if opts.reverse {
+			minObj, maxObj = stopObj, startObj
+		}
+		spec, ok := parseLexRange(minObj, maxObj)
+		if !ok {
+			c.addReplyError("ERR min or max not valid string range item")
+			return nil, false
+		}
+		if zobj == nil {
+			return nil, true
+		}
+		lo, hi = zsetRankRangeByLex(zobj, spec)
+	}
+
+	// LIMIT offset count，按返回方向计算
+	if opts.offset < 0 {
+		return nil, true
+	}
+	if opts.reverse {
+		hi -= int(opts.offset)
+		if opts.limit >= 0 && hi-int(opts.limit)+1 > lo {
+			lo = hi - int(opts.limit) + 1
+		}
+	} else {
+		lo += int(opts.offset)
+		if opts.limit >= 0 && lo+int(opts.limit)-1 < hi {
+			hi = lo + int(opts.limit) - 1
+		}
+	}
+	if lo > hi {
+		return nil, true
+	}
+	items := make([]zsetItem, 0, hi-lo+1)
+	zsetRangeByRank(zobj, lo, hi, opts.reverse, func(member *Obj, score float64) bool {
+		items = append(items, zsetItem{member: member, score: score})
+		return true
+	})
+	return items, true
+}
+
+func zrangeReply(c *Client, items []zsetItem, withScores bool) {
+	if withScores {
+		c.addReplyArrayLen(len(items) * 2)
+	} else {
+		c.addReplyArrayLen(len(items))
+	}
+	for _, item := range items {
+		c.addReplyBulkObj(item.member)
+		if withScores {
+			c.addReplyScore(item.score)
+		}
+	}
+}
+
+// ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func ZRange(c *Client, cmd *Cmd) {
+	opts, ok := parseZrangeOpts(c, c.args[4:])
+	if !ok {
+		return
+	}
+	zobj, ok := lookupZsetRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	items, ok := zrangeSelect(c, zobj, c.args[2], c.args[3], opts)
+	if !ok {
+		return
+	}
+	zrangeReply(c, items, opts.withScores)
+}
+
+// ZCOUNT key min max
+func ZCount(c *Client, cmd *Cmd) {
+	spec, ok := parseRange(c.args[2], c.args[3])
+	if !ok {
+		c.addReplyError("ERR min or max is not a float")
+		return
+	}
+	zobj, ok := lookupZsetRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if zobj == nil {
+		c.addReplyInt(0)
+		return
+	}
+	lo, hi := zsetRankRangeByScore(zobj, spec)
+	if hi < lo {
+		c.addReplyInt(0)
+		return
+	}
+	c.addReplyInt(int64(hi - lo + 1))
+}
+
+// zsetPop 弹出最小(或最大)的 count 个元素，返回的元素已增加引用计数，用完需释放
+func zsetPop(c *Client, key, zobj *Obj, max bool, count int) []zsetItem {
+	length := zsetLength(zobj)
+	if count > length {
+		count = length
+	}
+	lo, hi := 0, count-1
+	if max {
+		lo, hi = length-count, length-1
+	}
+	items := make([]zsetItem, 0, count)
+	zsetRangeByRank(zobj, lo, hi, max, func(member *Obj, score float64) bool {
+		member.incrRefCount()
+		items = append(items, zsetItem{member: member, score: score})
+		return true
+	})
+	zsetDeleteRangeByRank(zobj, lo, hi)
+	if zsetLength(zobj) == 0 {
+		dbDelete(c.db, key)
+	}
+	return items
+}
+
+func releaseZsetItems(items []zsetItem) {
+	for _, item := range items {
+		item.member.decrRefCount()
+	}
+}
+
+func zpopGeneric(c *Client, max bool) {
+	if len(c.args) > 3 {
+		c.addReplyError(respSyntaxErr)
+		return
+	}
+	count := int64(1)
+	if len(c.args) == 3 {
+		var ok bool
+		if count, ok = getPositiveInt64OrReply(c, c.args[2], ""); !ok {
+			return
+		}
+	}
+	zobj, ok := lookupZsetWrite(c, c.args[1])
+	if !ok {
+		return
+	}
+	if zobj == nil || count == 0 {
+		c.addReplyArrayLen(0)
+		return
+	}
+	items := zsetPop(c, c.args[1], zobj, max, int(count))
+	zrangeReply(c, items, true)
+	releaseZsetItems(items)
+}
+
+// ZPOPMIN key [count]
+func ZPopMin(c *Client, cmd *Cmd) {
+	zpopGeneric(c, false)
+}
+
+// ZPOPMAX key [count]
+func ZPopMax(c *Client, cmd *Cmd) {
+	zpopGeneric(c, true)
+}
+
+// zsetFromItems 用 items 创建新的 zset，元素少时使用 listpack 编码
+func zsetFromItems(items []zsetItem) *Obj {
+	zobj := createZsetListpackObject()
+	if len(items) > zsetMaxListpackEntries {
+		zsetConvert(zobj)
+	}
+	for _, item := range items {
+		zsetAdd(zobj, item.score, item.member, 0)
+	}
+	return zobj
+}
+
+// 将结果写入 dstKey，结果为空时删除 dstKey，回复结果个数
+func zsetStoreResult(c *Client, dstKey *Obj, items []zsetItem) {
+	if len(items) == 0 {
+		dbDelete(c.db, dstKey)
+		c.addReplyInt(0)
+		return
+	}
+	zobj := zsetFromItems(items)
+	setKey(c.db, dstKey, zobj)
+	zobj.decrRefCount()
+	c.addReplyInt(int64(len(items)))
+}
+
+// ZRANGESTORE dst src min max [BYSCORE|BYLEX] [REV] [LIMIT offset count]
+func ZRangeStore(c *Client, cmd *Cmd) {
+	opts, ok := parseZrangeOpts(c, c.args[5:])
+	if !ok {
+		return
+	}
+	if opts.withScores {
+		c.addReplyError(respSyntaxErr)
+		return
+	}
+	zobj, ok := lookupZsetRead(c, c.args[2])
+	if !ok {
+		return
+	}
+	items, ok := zrangeSelect(c, zobj, c.args[3], c.args[4], opts)
+	if !ok {
+		return
+	}
+	zsetStoreResult(c, c.args[1], items)
+}
+
+const (
+	zremrangeType_Rank  = 0
+	zremrangeType_Score = 1
+	zremrangeType_Lex   = 2
+)
+
+func zremrangeGeneric(c *Client, rtype int) {
+	key := c.args[1]
+	var (
+		start, end int64
+		spec       *zrangespec
+		lexSpec    *zlexrangespec
+		ok         bool
+	)
+	switch rtype {
+	case zremrangeType_Rank:
+		if start, ok = getInt64OrReply(c, c.args[2], ""); !ok {
+			return
+		}
+		if end, ok = getInt64OrReply(c, c.args[3], ""); !ok {
+			return
+		}
+	case zremrangeType_Score:
+		if spec, ok = parseRange(c.args[2], c.args[3]); !ok {
+			c.addReplyError("ERR min or max is not a float")
+			return
+		}
+	case zremrangeType_Lex:
+		if lexSpec, ok = parseLexRange(c.args[2], c.args[3]); !ok {
+			c.addReplyError("ERR min or max not valid string range item")
+			return
+		}
+	}
+	zobj, ok := lookupZsetWrite(c, key)
+	if !ok {
+		return
+	}
+	if zobj == nil {
+		c.addReplyInt(0)
+		return
+	}
+	var lo, hi int
+	switch rtype {
+	case zremrangeType_Rank:
+		if lo, hi, ok = normalizeRange(start, end, zsetLength(zobj)); !ok {
+			c.addReplyInt(0)
+			return
+		}
+	case zremrangeType_Score:
+		lo, hi = zsetRankRangeByScore(zobj, spec)
+	case zremrangeType_Lex:
+		lo, hi = zsetRankRangeByLex(zobj, lexSpec)
+	}
+	removed := zsetDeleteRangeByRank(zobj, lo, hi)
+	if zsetLength(zobj) == 0 {
+		dbDelete(c.db, key)
+	}
+	c.addReplyInt(int64(removed))
+}
+
+// ZREMRANGEBYRANK key start stop
+func ZRemRangeByRank(c *Client, cmd *Cmd) {
+	zremrangeGeneric(c, zremrangeType_Rank)
+}
+
+// ZREMRANGEBYSCORE key min max
+func ZRemRangeByScore(c *Client, cmd *Cmd) {
+	zremrangeGeneric(c, zremrangeType_Score)
+}
+
+// ZREMRANGEBYLEX key min max
+func ZRemRangeByLex(c *Client, cmd *Cmd) {
+	zremrangeGeneric(c, zremrangeType_Lex)
+}
+
+// ZLEXCOUNT key min max
+func ZLexCount(c *Client, cmd *Cmd) {
+	spec, ok := parseLexRange(c.args[2], c.args[3])
+	if !ok {
+		c.addReplyError("ERR min or max not valid string range item")
+		return
+	}
+	zobj, ok := lookupZsetRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if zobj == nil {
+		c.addReplyInt(0)
+		return
+	}
+	lo, hi := zsetRankRangeByLex(zobj, spec)
+	if hi < lo {
+		c.addReplyInt(0)
+		return
+	}
+	c.addReplyInt(int64(hi - lo + 1))
+}
+
+// zrandmemberSubStrategyMul ZRANDMEMBER 的 count 乘以该值仍超过元素个数时复制全部元素挑选，否则随机抽样
+const zrandmemberSubStrategyMul = 3
+
+// ZRANDMEMBER key [count [WITHSCORES]]
+func ZRandMember(c *Client, cmd *Cmd) {
+	if len(c.args) > 4 {
+		c.addReplyError(respSyntaxErr)
+		return
+	}
+	zobj, ok := lookupZsetRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if len(c.args) == 2 {
+		if zobj == nil {
+			c.addReplyNull()
+			return
+		}
+		c.addReplyBulkObj(zsetRandom(zobj).member)
+		return
+	}
+	// 和 redis 一样限制范围，避免 -count 溢出
+	count, ok := getRangeInt64OrReply(c, c.args[2], -math.MaxInt64/2, math.MaxInt64/2, "")
+	if !ok {
+		return
+	}
+	withScores := false
+	if len(c.args) == 4 {
+		if strings.ToUpper(c.args[3].ToStr()) != "WITHSCORES" {
+			c.addReplyError(respSyntaxErr)
+			return
+		}
+		withScores = true
+	}
+	if zobj == nil || count == 0 {
+		c.addReplyArrayLen(0)
+		return
+	}
+	if count < 0 { // 可重复
+		if withScores {
+			c.addReplyArrayLen(int(-count) * 2)
+		} else {
+			c.addReplyArrayLen(int(-count))
+		}
+		for i := int64(0); i < -count; i++ {
+			item := zsetRandom(zobj)
+			c.addReplyBulkObj(item.member)
+			if withScores {
+				c.addReplyScore(item.score)
+			}
+		}
+		return
+	}
+
+	// 不重复
+	size := int64(zsetLength(zobj))
+	var picked []zsetItem
+	switch {
+	case count >= size:
+		// 返回全部元素
+		picked = make([]zsetItem, 0, size)
+		zsetRangeByRank(zobj, 0, int(size)-1, false, func(member *Obj, score float64) bool {
+			picked = append(picked, zsetItem{member: member, score: score})
+			return true
+		})
+	case count*zrandmemberSubStrategyMul > size:
+		// count 接近元素个数，复制全部元素后随机挑选
+		all := make([]zsetItem, 0, size)
+		zsetRangeByRank(zobj, 0, int(size)-1, false, func(member *Obj, score float64) bool {
+			all = append(all, zsetItem{member: member, score: score})
+			return true
+		})
+		for i := 0; i < int(count); i++ {
+			j := i + rand.Intn(len(all)-i)
+			all[i], all[j] = all[j], all[i]
+		}
+		picked = all[:count]
+	default:
+		// count 远小于元素个数，随机抽样去重，不用复制整个zset
+		picked = make([]zsetItem, 0, count)
+		seen := make(map[string]struct{}, count)
+		for int64(len(picked)) < count {
+			item := zsetRandom(zobj)
+			if _, ok := seen[item.member.ToStr()]; ok {
+				continue
+			}
+			seen[item.member.ToStr()] = struct{}{}
+			picked = append(picked, item)
+		}
+	}
+	zrangeReply(c, picked, withScores)
+}
+
+// ZSCAN key cursor [MATCH pattern] [COUNT count]
+func ZScan(c *Client, cmd *Cmd) {
+	zobj, ok := lookupZsetRead(c, c.args[1])
+	if !ok {
+		return
+	}
+	if zobj == nil {
+		c.addReplyRaw(respEmptyScan)
+		return
+	}
+	scanGeneric(c, zobj, 2)
+}
+
+const (
+	zsetOpUnion = 0
+	zsetOpInter = 1
+	zsetOpDiff  = 2
+
+	aggregateSum = 0
+	aggregateMin = 1
+	aggregateMax = 2
+)
+
+// zunionInterDiff 的输入可以是 zset 也可以是 set (score 视为 1)
+func zsetOrSetLength(obj *Obj) int {
+	if obj.gType == Gtype_Set {
+		return setTypeSize(obj)
+	}
+	return zsetLength(obj)
+}
+
+func zsetOrSetRange(obj *Obj, fn func(member *Obj, score float64) bool) {
+	if obj.gType == Gtype_Set {
+		setTypeRange(obj, func(member *Obj) bool {
+			return fn(member, 1)
+		})
+		return
+	}
+	zsetRangeByRank(obj, 0, zsetLength(obj)-1, false, fn)
+}
+
+func zsetOrSetScore(obj *Obj, member *Obj) (float64, bool) {
+	if obj.gType == Gtype_Set {
+		return 1, setTypeIsMember(obj, member)
+	}
+	return zsetScore(obj, member)
+}
+
+func zaggregate(aggr int, cur, v float64) float64 {
+	switch aggr {
+	case aggregateMin:
+		return math.Min(cur, v)
+	case aggregateMax:
+		return math.Max(cur, v)
+	}
+	sum := cur + v
+	if math.IsNaN(sum) { // +inf 与 -inf 相加
+		return 0
+	}
+	return sum
+}
+
+// 带权重的 score，inf * 0 视为 0
+func weightedScore(score, weight float64) float64 {
+	v := score * weight
+	if math.IsNaN(v) {
+		return 0
+	}
+	return v
+}
+
+// zunionInterDiffGeneric ZUNION/ZINTER/ZDIFF 及 STORE 版本
+// dstKey 为 nil 时直接回复结果，numKeysIdx 为 numkeys 参数的位置
+func zunionInterDiffGeneric(c *Client, dstKey *Obj, numKeysIdx int, op int) {
+	numKeys, ok := getInt64OrReply(c, c.args[numKeysIdx], "")
+	if !ok {
+		return
+	}
+	if numKeys < 1 {
+		c.addReplyErrorf("ERR at least 1 input key is needed for '%s' command", strings.ToLower(c.args[0].ToStr()))
+		return
+	}
+	if numKeys > int64(len(c.args)-numKeysIdx-1) {
+		c.addReplyError(respSyntaxErr)
+		return
+	}
+	keys := c.args[numKeysIdx+1 : numKeysIdx+1+int(numKeys)]
+
+	weights := make([]float64, len(keys))
+	for i := range weights {
+		weights[i] = 1
+	}
+	aggr, withScores := aggregateSum, false
+	for i := numKeysIdx + 1 + int(numKeys); i < len(c.args); i++ {
+		opt := strings.ToUpper(c.args[i].ToStr())
+		remaining := len(c.args) - i - 1
+		switch {
+		case opt == "WEIGHTS" && op != zsetOpDiff && remaining >= len(keys):
+			for j := range weights {
+				v, err := strconv.ParseFloat(c.args[i+1+j].ToStr(), 64)
+				if err != nil || math.IsNaN(v) {
+					c.addReplyError("ERR weight value is not a float")
+					return
+				}
+				weights[j] = v
+			}
+			i += len(keys)
+		case opt == "AGGREGATE" && op != zsetOpDiff && remaining >= 1:
+			switch strings.ToUpper(c.args[i+1].ToStr()) {
+			case "SUM":
+				aggr = aggregateSum
+			case "MIN":
+				aggr = aggregateMin
+			case "MAX":
+				aggr = aggregateMax
+			default:
+				c.addReplyError(respSyntaxErr)
+				return
+			}
+			i++
+		case opt == "WITHSCORES" && dstKey == nil:
+			withScores = true
+		default:
+			c.addReplyError(respSyntaxErr)
+			return
+		}
+	}
+
+	srcs := make([]*Obj, len(keys))
+	for i, key := range keys {
+		obj := lookupKeyRead(c.db, key)
+		if obj != nil && obj.gType != GType_ZSet && obj.gType != Gtype_Set {
+			c.addReplyError(respWrongType)
+			return
+		}
+		srcs[i] = obj
+	}
+
+	var items []zsetItem
+	switch op {
+	case zsetOpUnion:
+		index := make(map[string]int)
+		for i, src := range srcs {
+			if src == nil {
+				continue
+			}
+			zsetOrSetRange(src, func(member *Obj, score float64) bool {
+				score = weightedScore(score, weights[i])
+				if j, ok := index[member.ToStr()]; ok {
+					items[j].score = zaggregate(aggr, items[j].score, score)
+				} else {
+					index[member.ToStr()] = len(items)
+					items = append(items, zsetItem{member: member, score: score})
+				}
+				return true
+			})
+		}
+	case zsetOpInter:
+		// 从最小的输入开始遍历
+		smallest := 0
+		for i, src := range srcs {
+			if src == nil {
+				smallest = -1
+				break
+			}
+			if zsetOrSetLength(src) < zsetOrSetLength(srcs[smallest]) {
+				smallest = i
+			}
+		}
+		if smallest < 0 {
+			break
+		}
+		zsetOrSetRange(srcs[smallest], func(member *Obj, raw float64) bool {
+			score := weightedScore(raw, weights[smallest])
+			for i, src := range srcs {
+				if i == smallest {
+					continue
+				}
+				// 同一个key重复出现时不再查询正在遍历的对象
+				other := raw
+				if src != srcs[smallest] {
+					var ok bool
+					if other, ok = zsetOrSetScore(src, member); !ok {
+						return true
+					}
+				}
+				score = zaggregate(aggr, score, weightedScore(other, weights[i]))
+			}
+			items = append(items, zsetItem{member: member, score: score})
+			return true
+		})
+	case zsetOpDiff:
+		if srcs[0] == nil {
+			break
+		}
+		zsetOrSetRange(srcs[0], func(member *Obj, score float64) bool {
+			for _, src := range srcs[1:] {
+				if src == nil {
+					continue
+				}
+				if src == srcs[0] {
+					return true
+				}
+				if _, ok := zsetOrSetScore(src, member); ok {
+					return true
+				}
+			}
+			items = append(items, zsetItem{member: member, score: score})
+			return true
+		})
+	}
+
+	if dstKey != nil {
+		zsetStoreResult(c, dstKey, items)
+		return
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return zslLess(items[i].score, items[i].member, items[j].score, items[j].member)
+	})
+	zrangeReply(c, items, withScores)
+}
+
+// ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+func ZUnionStore(c *Client, cmd *Cmd) {
+	zunionInterDiffGeneric(c, c.args[1], 2, zsetOpUnion)
+}
+
+// ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX]
+func ZInterStore(c *Client, cmd *Cmd) {
+	zunionInterDiffGeneric(c, c.args[1], 2, zsetOpInter)
+}
+
+// ZDIFFSTORE destination numkeys key [key ...]
+func ZDiffStore(c *Client, cmd *Cmd) {
+	zunionInterDiffGeneric(c, c.args[1], 2, zsetOpDiff)
+}
+
+// ZUNION numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func ZUnion(c *Client, cmd *Cmd) {
+	zunionInterDiffGeneric(c, nil, 1, zsetOpUnion)
+}
+
+// ZINTER numkeys key [key ...] [WEIGHTS weight ...] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func ZInter(c *Client, cmd *Cmd) {
+	zunionInterDiffGeneric(c, nil, 1, zsetOpInter)
+}
+
+// ZDIFF numkeys key [key ...] [WITHSCORES]
+func ZDiff(c *Client, cmd *Cmd) {
+	zunionInterDiffGeneric(c, nil, 1, zsetOpDiff)
+}
+
+// 从非空zset弹出并回复，count 为 0 时回复 [key, member, score]，否则回复 [key, [[member, score] ...]]
+func zsetPopAndReply(c *Client, key, zobj *Obj, max bool, count int64) {
+	n := count
+	if n == 0 {
+		n = 1
+	}
+	items := zsetPop(c, key, zobj, max, int(n))
+	if count == 0 {
+		c.addReplyArrayLen(3)
+		c.addReplyBulkObj(key)
+		c.addReplyBulkObj(items[0].member)
+		c.addReplyScore(items[0].score)
+	} else {
+		c.addReplyArrayLen(2)
+		c.addReplyBulkObj(key)
+		c.addReplyArrayLen(len(items))
+		for _, item := range items {
+			c.addReplyArrayLen(2)
+			c.addReplyBulkObj(item.member)
+			c.addReplyScore(item.score)
+		}
+	}
+	releaseZsetItems(items)
+}
+
+func blockingZpopGeneric(c *Client, keys []*Obj, max bool, count int64, timeout int64) {
+	for _, key := range keys {
+		zobj, ok := lookupZsetWrite(c, key)
+		if !ok {
+			return
+		}
+		if zobj == nil {
+			continue
+		}
+		zsetPopAndReply(c, key, zobj, max, count)
+		return
+	}
+	c.bstate.zmax = max
+	c.bstate.count = count
+	blockForKeys(c, blockType_ZSet, keys, timeout)
+}
+
+// BZPOPMIN key [key ...] timeout
+func BZPopMin(c *Client, cmd *Cmd) {
+	timeout, ok := getTimeoutOrReply(c, c.args[len(c.args)-1])
+	if !ok {
+		return
+	}
+	blockingZpopGeneric(c, c.args[1:len(c.args)-1], false, 0, timeout)
+}
+
+// BZPOPMAX key [key ...] timeout
+func BZPopMax(c *Client, cmd *Cmd) {
+	timeout, ok := getTimeoutOrReply(c, c.args[len(c.args)-1])
+	if !ok {
+		return
+	}
+	blockingZpopGeneric(c, c.args[1:len(c.args)-1], true, 0, timeout)
+}
+
+// BZMPOP timeout numkeys key [key ...] MIN|MAX [COUNT count]
+func BZMPop(c *Client, cmd *Cmd) {
+	timeout, ok := getTimeoutOrReply(c, c.args[1])
+	if !ok {
+		return
+	}
+	numKeys, ok := getInt64OrReply(c, c.args[2], "")
+	if !ok {
+		return
+	}
+	if numKeys <= 0 {
+		c.addReplyError("ERR numkeys should be greater than 0")
+		return
+	}
+	whereIdx := 3 + numKeys
+	if whereIdx >= int64(len(c.args)) {
+		c.addReplyError(respSyntaxErr)
+		return
+	}
+	var max bool
+	switch strings.ToUpper(c.args[whereIdx].ToStr()) {
+	case "MIN":
+		max = false
+	case "MAX":
+		max = true
+	default:
+		c.addReplyError(respSyntaxErr)
+		return
+	}
+	count := int64(1)
+	for i := whereIdx + 1; i < int64(len(c.args)); i++ {
+		if strings.ToUpper(c.args[i].ToStr()) == "COUNT" && i+1 < int64(len(c.args)) {
+			if count, ok = getInt64OrReply(c, c.args[i+1], ""); !ok {
+				return
+			}
+			if count <= 0 {
+				c.addReplyError("ERR count should be greater than 0")
+				return
+			}
+			i++
+		} else {
+			c.addReplyError(respSyntaxErr)
+			return
+		}
+	}
+	blockingZpopGeneric(c, c.args[3:whereIdx], max, count, timeout)
+}
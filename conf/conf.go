@@ -2,12 +2,37 @@ package conf
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 )
 
+const (
+	defaultDatabases        = 16
+	defaultMaxmemoryPolicy  = "noeviction"
+	defaultMaxmemorySamples = 5
+	defaultLfuLogFactor     = 10
+	defaultLfuDecayTime     = 1
+)
+
 type Config struct {
-	Port int `json:"port"`
+	Port      int `json:"port"`
+	Databases int `json:"databases"` // 逻辑db个数
+
+	Maxmemory        int64  `json:"maxmemory"`         // 内存上限(字节)，0 表示不限制
+	MaxmemoryPolicy  string `json:"maxmemory-policy"`  // 达到上限时的淘汰策略
+	MaxmemorySamples int    `json:"maxmemory-samples"` // 每次淘汰抽样的key个数
+	LfuLogFactor     int    `json:"lfu-log-factor"`    // LFU 计数器增长的对数因子
+	LfuDecayTime     int    `json:"lfu-decay-time"`    // LFU 计数器每隔多少分钟衰减 1
+
+	ActiveRehashing bool `json:"activerehashing"` // serverCron 中主动推进db的rehash
+
+	// 以下场景是否在后台释放大对象
+	LazyfreeLazyEviction bool `json:"lazyfree-lazy-eviction"`   // 内存淘汰
+	LazyfreeLazyExpire   bool `json:"lazyfree-lazy-expire"`     // 过期删除
+	LazyfreeLazyServer   bool `json:"lazyfree-lazy-server-del"` // 覆盖、RENAME 等隐式删除
+	LazyfreeLazyUserDel  bool `json:"lazyfree-lazy-user-del"`   // DEL 等同于 UNLINK
+	LazyfreeLazyFlush    bool `json:"lazyfree-lazy-user-flush"` // FLUSHDB/FLUSHALL 默认 ASYNC
 }
 
 func LoadConf(path string) (*Config, error) {
@@ -20,9 +45,28 @@ func LoadConf(path string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	cf := &Config{}
+	cf := &Config{
+		Databases:        defaultDatabases,
+		MaxmemoryPolicy:  defaultMaxmemoryPolicy,
+		MaxmemorySamples: defaultMaxmemorySamples,
+		LfuLogFactor:     defaultLfuLogFactor,
+		LfuDecayTime:     defaultLfuDecayTime,
+		ActiveRehashing:  true,
+	}
 	if err = json.Unmarshal(jsonBytes, &cf); err != nil {
 		return nil, err
 	}
+	if cf.Databases < 1 {
+		return nil, fmt.Errorf("invalid databases %d", cf.Databases)
+	}
+	if cf.Maxmemory < 0 {
+		return nil, fmt.Errorf("invalid maxmemory %d", cf.Maxmemory)
+	}
+	if cf.MaxmemorySamples < 1 || cf.MaxmemorySamples > 64 {
+		return nil, fmt.Errorf("invalid maxmemory-samples %d", cf.MaxmemorySamples)
+	}
+	if cf.LfuLogFactor < 0 || cf.LfuDecayTime < 0 {
+		return nil, fmt.Errorf("invalid lfu-log-factor %d or lfu-decay-time %d", cf.LfuLogFactor, cf.LfuDecayTime)
+	}
 	return cf, nil
 }
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/draymonders/gmem/ae"
+)
+
+/*
+   db层的key操作，命令统一通过这里读写keyspace
+*/
+
+func newDB(id int) *DB {
+	return &DB{
+		id:      id,
+		expires: NewDict(StrDictType),
+		dict:    NewDict(StrDictType),
+
+		blockingKeys: make(map[string][]*Client),
+		readyKeys:    make(map[string]struct{}),
+	}
+}
+
+// selectDb 切换client当前的db，id 越界返回 false
+func selectDb(c *Client, id int64) bool {
+	if id < 0 || id >= int64(len(server.dbs)) {
+		return false
+	}
+	c.db = server.dbs[id]
+	return true
+}
+
+// emptyDb 清空db，返回删除的key个数
+// async 为 true 时旧的dict交给后台释放，不在主线程逐个释放
+func emptyDb(db *DB, async bool) int {
+	removed := db.dict.Len()
+	dict, expires := db.dict, db.expires
+	db.dict = NewDict(StrDictType)
+	db.expires = NewDict(StrDictType)
+	db.usedMemory = 0
+	if async && removed > lazyfreeThreshold {
+		lazyfreeSubmit(expires)
+		lazyfreeSubmit(dict)
+	} else {
+		expires.Release()
+		dict.Release()
+	}
+	return removed
+}
+
+const activeRehashMilliseconds = 1 // 每次 serverCron 主动rehash的耗时上限
+
+var rehashCurrentDb int // 下次从哪个db开始主动rehash
+
+// incrementallyRehash 推进db的rehash，先 dict 后 expires，有rehash时返回 true
+func incrementallyRehash(db *DB) bool {
+	if db.dict.isRehash() {
+		db.dict.RehashMilliseconds(activeRehashMilliseconds)
+		return true
+	}
+	if db.expires.isRehash() {
+		db.expires.RehashMilliseconds(activeRehashMilliseconds)
+		return true
+	}
+	return false
+}
+
+// activeRehashCycle 没有读写的db不会推进rehash，由 serverCron 定期推进
+// 每次只处理一个正在rehash的db，轮流进行
+func activeRehashCycle() {
+	for i := 0; i < len(server.dbs); i++ {
+		db := server.dbs[rehashCurrentDb%len(server.dbs)]
+		rehashCurrentDb++
+		if incrementallyRehash(db) {
+			return
+		}
+	}
+}
+
+// swapDb 交换两个db的数据，阻塞在key上的client仍然留在原来的db
+func swapDb(id1, id2 int) {
+	db1, db2 := server.dbs[id1], server.dbs[id2]
+	db1.dict, db2.dict = db2.dict, db1.dict
+	db1.expires, db2.expires = db2.expires, db1.expires
+	db1.usedMemory, db2.usedMemory = db2.usedMemory, db1.usedMemory
+	// 交换后阻塞的key可能已经有数据了
+	scanDatabaseForReadyKeys(db1)
+	scanDatabaseForReadyKeys(db2)
+}
+
+const (
+	lookupFlag_NoTouch = 1 << 0 // 不更新value的访问信息，OBJECT 等查看元数据的命令和内部查询使用
+)
+
+// lookupKey 直接查询keyspace，不检查过期，命令应使用 lookupKeyRead/lookupKeyWrite
+// 命中时更新value的访问信息，供内存淘汰使用
+func lookupKey(db *DB, key *Obj, flags int) *Obj {
+	val := db.dict.Get(key)
+	if val != nil && flags&lookupFlag_NoTouch == 0 {
+		updateObjectAccess(val)
+	}
+	return val
+}
+
+// keyIsExpired key设置了过期时间且已过期
+func keyIsExpired(db *DB, key *Obj) bool {
+	when := getExpire(db, key)
+	if when < 0 {
+		return false
+	}
+	return ae.GetUnixTime() > when
+}
+
+// expireIfNeeded key已过期时删除，返回是否被删除
+func expireIfNeeded(db *DB, key *Obj) bool {
+	if !keyIsExpired(db, key) {
+		return false
+	}
+	server.statExpiredKeys++
+	dbGenericDelete(db, key, server.lazyfreeLazyExpire)
+	return true
+}
+
+// lookupKeyRead 读命令查询key，已过期的key先删除，同时统计命中率
+func lookupKeyRead(db *DB, key *Obj) *Obj {
+	return lookupKeyReadWithFlags(db, key, 0)
+}
+
+// lookupKeyReadWithFlags flags 为 lookupFlag_*
+func lookupKeyReadWithFlags(db *DB, key *Obj, flags int) *Obj {
+	expireIfNeeded(db, key)
+	val := lookupKey(db, key, flags)
+	if val == nil {
+		server.statKeyspaceMisses++
+	} else {
+		server.statKeyspaceHits++
+	}
+	return val
+}
+
+// lookupKeyWrite 写命令查询key，已过期的key先删除，之后可以安全地 dbAdd
+// 取到的value可能被修改，命令结束后重新计算大小
+func lookupKeyWrite(db *DB, key *Obj) *Obj {
+	expireIfNeeded(db, key)
+	val := lookupKey(db, key, 0)
+	if val != nil {
+		touchKeyMemory(db, key, val)
+	}
+	return val
+}
+
+// dbAdd 添加新key，调用方保证key不存在
+func dbAdd(db *DB, key, val *Obj) {
+	_ = db.dict.Add(key, val)
+	val.memSize = 0
+	db.usedMemory += keyMemory(key) + accountValue(val)
+	touchKeyMemory(db, key, val)
+	if val.gType == GType_List || val.gType == GType_ZSet {
+		signalKeyAsReady(db, key)
+	}
+}
+
+// dbOverwrite 覆盖已存在的key，开启 lazyfree-lazy-server-del 时旧value在后台释放
+func dbOverwrite(db *DB, key, val *Obj) {
+	old := db.dict.Get(key)
+	lazy := false
+	if old != nil {
+		db.usedMemory -= int64(old.memSize)
+		// 多持有一个引用，dict释放后由后台释放最后一个引用
+		if lazy = server.lazyfreeLazyServer && lazyfreeShouldFree(old); lazy {
+			old.incrRefCount()
+		}
+	}
+	_ = db.dict.Set(key, val)
+	if lazy {
+		lazyfreeSubmit(old)
+	}
+	val.memSize = 0
+	db.usedMemory += accountValue(val)
+	touchKeyMemory(db, key, val)
+}
+
+// setKey key存在则覆盖，不存在则添加，同时清除key的过期时间
+func setKey(db *DB, key, val *Obj) {
+	if lookupKey(db, key, lookupFlag_NoTouch) == nil {
+		dbAdd(db, key, val)
+	} else {
+		dbOverwrite(db, key, val)
+	}
+	removeExpire(db, key)
+}
+
+// dbDelete 隐式删除key，由 lazyfree-lazy-server-del 决定是否后台释放
+func dbDelete(db *DB, key *Obj) bool {
+	return dbGenericDelete(db, key, server.lazyfreeLazyServer)
+}
+
+// dbSyncDelete 在主线程释放value
+func dbSyncDelete(db *DB, key *Obj) bool {
+	return dbGenericDelete(db, key, false)
+}
+
+// dbAsyncDelete 大的value交给后台释放
+func dbAsyncDelete(db *DB, key *Obj) bool {
+	return dbGenericDelete(db, key, true)
+}
+
+func dbGenericDelete(db *DB, key *Obj, async bool) bool {
+	removeExpire(db, key)
+	val := db.dict.Get(key)
+	if val == nil {
+		return false
+	}
+	db.usedMemory -= keyMemory(key) + int64(val.memSize)
+	entry := db.dict.Unlink(key)
+	if async && lazyfreeShouldFree(val) {
+		// dict 对 value 的引用转交给后台
+		entry.val = nil
+		db.dict.FreeUnlinkedEntry(entry)
+		lazyfreeSubmit(val)
+	} else {
+		db.dict.FreeUnlinkedEntry(entry)
+	}
+	return true
+}
+
+// setExpire 设置key的过期时间，when 为毫秒级unix时间戳，调用方保证key存在
+func setExpire(db *DB, key *Obj, when int64) {
+	obj := NewObjectFromInt64(when)
+	if err := db.expires.Set(key, obj); err != nil {
+		_ = db.expires.Add(key, obj)
+	}
+	obj.decrRefCount()
+}
+
+// getExpire 返回key的过期时间(ms)，没有设置过期时间时返回 -1
+func getExpire(db *DB, key *Obj) int64 {
+	obj := db.expires.Get(key)
+	if obj == nil {
+		return -1
+	}
+	return obj.ptr.(int64)
+}
+
+func removeExpire(db *DB, key *Obj) bool {
+	return db.expires.Del(key)
+}
+
+// scanGeneric SCAN/HSCAN/SSCAN/ZSCAN 的通用实现，c.args[cursorIdx] 为游标，之后为可选参数
+// obj 为 nil 时遍历 keyspace，否则遍历容器内的元素
+// hashtable/skiplist 编码按游标分批遍历，listpack/intset 编码元素少，一次全部返回
+func scanGeneric(c *Client, obj *Obj, cursorIdx int) {
+	cursor, err := strconv.ParseUint(c.args[cursorIdx].ToStr(), 10, 64)
+	if err != nil {
+		c.addReplyError("ERR invalid cursor")
+		return
+	}
+	count := int64(10)
+	pattern, typeName := "", ""
+	for i := cursorIdx + 1; i < len(c.args); i += 2 {
+		if i+1 >= len(c.args) {
+			c.addReplyError(respSyntaxErr)
+			return
+		}
+		switch opt := strings.ToUpper(c.args[i].ToStr()); {
+		case opt == "COUNT":
+			var ok bool
+			if count, ok = getInt64OrReply(c, c.args[i+1], ""); !ok {
+				return
+			}
+			if count < 1 {
+				c.addReplyError(respSyntaxErr)
+				return
+			}
+		case opt == "MATCH":
+			pattern = c.args[i+1].ToStr()
+			if pattern == "*" {
+				pattern = ""
+			}
+		case opt == "TYPE" && obj == nil:
+			typeName = strings.ToLower(c.args[i+1].ToStr())
+		default:
+			c.addReplyError(respSyntaxErr)
+			return
+		}
+	}
+
+	// pairs 为 true 时 items 按 key,val 成对存放
+	items := make([]*Obj, 0)
+	pairs := false
+	var d *Dict
+	switch {
+	case obj == nil:
+		d = c.db.dict
+	case obj.encoding == GEncoding_Hashtable:
+		d = obj.ptr.(*Dict)
+		pairs = obj.gType == GType_Dict
+	case obj.encoding == GEncoding_Skiplist:
+		d = obj.ptr.(*ZSet).dict
+		pairs = true
+	}
+	if d != nil {
+		// 稀疏的表可能连续很多空bucket，限制最多遍历的bucket数
+		maxIterations := count * 10
+		for {
+			cursor = d.Scan(cursor, func(key, val *Obj) {
+				items = append(items, key)
+				if pairs {
+					items = append(items, val)
+				}
+			})
+			maxIterations--
+			if cursor == 0 || maxIterations <= 0 || int64(len(items)) >= count {
+				break
+			}
+		}
+	} else {
+		cursor = 0
+		switch obj.gType {
+		case GType_Dict:
+			pairs = true
+			hashTypeRange(obj, func(field, val *Obj) bool {
+				items = append(items, field, val)
+				return true
+			})
+		case Gtype_Set:
+			setTypeRange(obj, func(member *Obj) bool {
+				items = append(items, member)
+				return true
+			})
+		case GType_ZSet:
+			pairs = true
+			zsetRangeByRank(obj, 0, zsetLength(obj)-1, false, func(member *Obj, score float64) bool {
+				items = append(items, member, newScoreObj(score))
+				return true
+			})
+		}
+	}
+
+	// 按 MATCH/TYPE 过滤，keyspace 中已过期的key顺便删除
+	step := 1
+	if pairs {
+		step = 2
+	}
+	filtered := make([]*Obj, 0, len(items))
+	for i := 0; i < len(items); i += step {
+		key := items[i]
+		if pattern != "" && !stringMatch(pattern, key.ToStr(), false) {
+			continue
+		}
+		if obj == nil {
+			if typeName != "" {
+				if val := lookupKey(c.db, key, lookupFlag_NoTouch); val == nil || val.typeName() != typeName {
+					continue
+				}
+			}
+			key.incrRefCount()
+			expired := expireIfNeeded(c.db, key)
+			key.decrRefCount()
+			if expired {
+				continue
+			}
+		}
+		filtered = append(filtered, items[i:i+step]...)
+	}
+
+	c.addReplyArrayLen(2)
+	c.addReplyBulk(strconv.FormatUint(cursor, 10))
+	c.addReplyArrayLen(len(filtered))
+	for _, item := range filtered {
+		c.addReplyBulkObj(item)
+	}
+}
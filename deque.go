@@ -0,0 +1,261 @@
+package main
+
+const dequeMinCap = 8 // 环形缓冲区最小容量
+
+// Deque 基于环形缓冲区的双端队列，list类型的底层结构
+// 两端 push/pop 均摊 O(1)，下标访问 O(1)，中间插入删除 O(n)
+// 入队时增加元素引用计数，出队时所有权交给调用方
+type Deque struct {
+	buf  []*Obj // len(buf) must be power(2, x)
+	head int    // 第一个元素在buf中的位置
+	len  int
+}
+
+func NewDeque() *Deque {
+	return &Deque{buf: make([]*Obj, dequeMinCap)}
+}
+
+func (dq *Deque) Len() int {
+	return dq.len
+}
+
+// 逻辑下标 -> buf下标
+func (dq *Deque) pos(i int) int {
+	return (dq.head + i) & (len(dq.buf) - 1)
+}
+
+// 容量调整为 size，元素重新从0开始排列
+func (dq *Deque) resize(size int) {
+	buf := make([]*Obj, size)
+	for i := 0; i < dq.len; i++ {
+		buf[i] = dq.buf[dq.pos(i)]
+	}
+	dq.buf = buf
+	dq.head = 0
+}
+
+func (dq *Deque) growIfNeed() {
+	if dq.len == len(dq.buf) {
+		dq.resize(len(dq.buf) << 1)
+	}
+}
+
+// 元素数不足容量的1/4时缩容，避免弹出大量元素后占着大数组
+func (dq *Deque) shrinkIfNeed() {
+	if len(dq.buf) > dequeMinCap && dq.len < len(dq.buf)>>2 {
+		dq.resize(len(dq.buf) >> 1)
+	}
+}
+
+func (dq *Deque) PushFront(val *Obj) {
+	dq.growIfNeed()
+	dq.head = (dq.head - 1) & (len(dq.buf) - 1)
+	dq.buf[dq.head] = val
+	dq.len++
+	val.incrRefCount()
+}
+
+func (dq *Deque) PushBack(val *Obj) {
+	dq.growIfNeed()
+	dq.buf[dq.pos(dq.len)] = val
+	dq.len++
+	val.incrRefCount()
+}
+
+func (dq *Deque) PopFront() *Obj {
+	if dq.len == 0 {
+		return nil
+	}
+	val := dq.buf[dq.head]
+	dq.buf[dq.head] = nil
+	dq.head = dq.pos(1)
+	dq.len--
+	dq.shrinkIfNeed()
+	return val
+}
+
+func (dq *Deque) PopBack() *Obj {
+	if dq.len == 0 {
+		return nil
+	}
+	idx := dq.pos(dq.len - 1)
+	val := dq.buf[idx]
+	dq.buf[idx] = nil
+	dq.len--
+	dq.shrinkIfNeed()
+	return val
+}
+
+// Index 获取第i个元素，i 范围 [0, len)
+func (dq *Deque) Index(i int) *Obj {
+	if i < 0 || i >= dq.len {
+		return nil
+	}
+	return dq.buf[dq.pos(i)]
+}
+
+// Set 替换第i个元素
+func (dq *Deque) Set(i int, val *Obj) bool {
+	if i < 0 || i >= dq.len {
+		return false
+	}
+	idx := dq.pos(i)
+	val.incrRefCount()
+	dq.buf[idx].decrRefCount()
+	dq.buf[idx] = val
+	return true
+}
+
+// Insert 在第i个元素之前插入，i == len 时追加到末尾
+// 挪动离插入点较近的一端
+func (dq *Deque) Insert(i int, val *Obj) {
+	if i <= 0 {
+		dq.PushFront(val)
+		return
+	}
+	if i >= dq.len {
+		dq.PushBack(val)
+		return
+	}
+	dq.growIfNeed()
+	if i < dq.len/2 {
+		dq.head = (dq.head - 1) & (len(dq.buf) - 1)
+		for j := 0; j < i; j++ {
+			dq.buf[dq.pos(j)] = dq.buf[dq.pos(j+1)]
+		}
+	} else {
+		for j := dq.len; j > i; j-- {
+			dq.buf[dq.pos(j)] = dq.buf[dq.pos(j-1)]
+		}
+	}
+	dq.buf[dq.pos(i)] = val
+	dq.len++
+	val.incrRefCount()
+}
+
+// Remove 删除第i个元素，并释放其引用
+func (dq *Deque) Remove(i int) {
+	if i < 0 || i >= dq.len {
+		return
+	}
+	dq.buf[dq.pos(i)].decrRefCount()
+	if i < dq.len/2 {
+		for j := i; j > 0; j-- {
+			dq.buf[dq.pos(j)] = dq.buf[dq.pos(j-1)]
+		}
+		dq.buf[dq.head] = nil
+		dq.head = dq.pos(1)
+	} else {
+		for j := i; j < dq.len-1; j++ {
+			dq.buf[dq.pos(j)] = dq.buf[dq.pos(j+1)]
+		}
+		dq.buf[dq.pos(dq.len-1)] = nil
+	}
+	dq.len--
+	dq.shrinkIfNeed()
+}
+
+// Range 按顺序遍历 [start, end] 的元素，fn 返回 false 时停止
+func (dq *Deque) Range(start, end int, fn func(i int, val *Obj) bool) {
+	if start < 0 {
+		start = 0
+	}
+	if end >= dq.len {
+		end = dq.len - 1
+	}
+	for i := start; i <= end; i++ {
+		if !fn(i, dq.buf[dq.pos(i)]) {
+			return
+		}
+	}
+}
+
+// RangeReverse 从 end 到 start 倒序遍历
+func (dq *Deque) RangeReverse(start, end int, fn func(i int, val *Obj) bool) {
+	if start < 0 {
+		start = 0
+	}
+	if end >= dq.len {
+		end = dq.len - 1
+	}
+	for i := end; i >= start; i-- {
+		if !fn(i, dq.buf[dq.pos(i)]) {
+			return
+		}
+	}
+}
+
+// Trim 只保留 [start, end] 的元素，其余释放
+func (dq *Deque) Trim(start, end int) {
+	if start < 0 {
+		start = 0
+	}
+	if end >= dq.len {
+		end = dq.len - 1
+	}
+	if start > end {
+		start, end = dq.len, dq.len-1
+	}
+	for i := 0; i < start; i++ {
+		idx := dq.pos(i)
+		dq.buf[idx].decrRefCount()
+		dq.buf[idx] = nil
+	}
+	for i := end + 1; i < dq.len; i++ {
+		idx := dq.pos(i)
+		dq.buf[idx].decrRefCount()
+		dq.buf[idx] = nil
+	}
+	dq.head = dq.pos(start)
+	dq.len = end - start + 1
+	for dq.len < len(dq.buf)>>2 && len(dq.buf) > dequeMinCap {
+		dq.resize(len(dq.buf) >> 1)
+	}
+}
+
+// RemoveIf 删除满足 fn 的元素，limit <= 0 表示不限个数，fromTail 表示从尾部开始匹配
+// 一次遍历完成压缩，返回删除个数
+func (dq *Deque) RemoveIf(fromTail bool, limit int, fn func(val *Obj) bool) int {
+	removed := 0
+	match := func(val *Obj) bool {
+		return (limit <= 0 || removed < limit) && fn(val)
+	}
+	if !fromTail {
+		w := 0
+		for r := 0; r < dq.len; r++ {
+			val := dq.buf[dq.pos(r)]
+			if match(val) {
+				val.decrRefCount()
+				removed++
+				continue
+			}
+			dq.buf[dq.pos(w)] = val
+			w++
+		}
+		for i := w; i < dq.len; i++ {
+			dq.buf[dq.pos(i)] = nil
+		}
+		dq.len = w
+	} else {
+		w := dq.len - 1
+		for r := dq.len - 1; r >= 0; r-- {
+			val := dq.buf[dq.pos(r)]
+			if match(val) {
+				val.decrRefCount()
+				removed++
+				continue
+			}
+			dq.buf[dq.pos(w)] = val
+			w--
+		}
+		for i := 0; i <= w; i++ {
+			dq.buf[dq.pos(i)] = nil
+		}
+		dq.head = dq.pos(w + 1)
+		dq.len -= w + 1
+	}
+	for dq.len < len(dq.buf)>>2 && len(dq.buf) > dequeMinCap {
+		dq.resize(len(dq.buf) >> 1)
+	}
+	return removed
+}
@@ -2,10 +2,11 @@ package main
 
 import (
 	"errors"
-	"hash/crc32"
-	"log"
+	"math/bits"
 	"math/rand"
+	"strings"
 	"time"
+	"unsafe"
 )
 
 func init() {
@@ -18,19 +19,39 @@ var (
 )
 
 const (
-	loadFactor = 1  // 负载因子
-	initSize   = 16 // 初始hTable bucket数
+	loadFactor       = 1  // 负载因子
+	initSize         = 16 // 初始hTable bucket数
+	hashtableMinFill = 10 // 填充率低于该百分比时缩容
+	forceResizeRatio = 5  // 禁止resize时，负载超过该倍数仍然扩容、低于 1/该倍数 仍然缩容
 )
 
+// dictCanResize 全局的resize开关，持有大量dict的快照期间可以关闭，避免rehash产生的内存拷贝
+var dictCanResize = true
+
+// DictSetResizeEnabled 打开/关闭所有dict的resize
+func DictSetResizeEnabled(enable bool) {
+	dictCanResize = enable
+}
+
+// DictType 决定key如何哈希和比较，以及元素插入、删除时如何复制和释放，预定义的类型见 dicttype.go
+// 可选的函数为 nil 时使用默认行为：key 按 ToStr 计算哈希，插入时增加引用计数，删除时减少引用计数
 type DictType struct {
-	HashFn  func(key *Obj) int
-	EqualFn func(k1, k2 *Obj) bool
+	HashFn     func(key []byte) uint64 // 哈希函数见 siphash.go
+	KeyBytesFn func(key *Obj) []byte   // 参与哈希的key内容
+	EqualFn    func(k1, k2 *Obj) bool
+
+	KeyDupFn        func(key *Obj) *Obj // 插入时复制key，dict保存返回值
+	ValDupFn        func(val *Obj) *Obj
+	KeyDestructorFn func(key *Obj) // 删除、覆盖或 Release 时释放
+	ValDestructorFn func(val *Obj)
 }
 
 type Dict struct {
 	DictType
 	ht        [2]*hTable // 惰性加载
-	rehashIdx int        // -1 没在扩容，其余表示在扩容
+	rehashIdx int        // -1 没在rehash，其余为 ht[0] 下一个要迁移的bucket
+
+	pauseRehash int // 存活的安全迭代器个数，大于 0 时不迁移bucket
 }
 
 type hTable struct {
@@ -46,19 +67,72 @@ type hEntry struct {
 	next *hEntry
 }
 
-func Hash(key *Obj) int {
-	if key.gType != GType_Str {
-		log.Printf("key %+v type not str", key)
-		return -1
+func Equal(k1, k2 *Obj) bool {
+	if k1.gType != GType_Str || k2.gType != GType_Str {
+		return false
+	}
+	if k1.encoding == GEncoding_Int && k2.encoding == GEncoding_Int {
+		return k1.ptr.(int64) == k2.ptr.(int64)
 	}
-	return int(crc32.ChecksumIEEE([]byte(key.ptr.(string))))
+	return k1.ToStr() == k2.ToStr()
 }
 
-func Equal(k1, k2 *Obj) bool {
+// CaseEqual 大小写不敏感的比较，配合 CaseHash 使用
+func CaseEqual(k1, k2 *Obj) bool {
 	if k1.gType != GType_Str || k2.gType != GType_Str {
 		return false
 	}
-	return k1.ptr.(string) == k2.ptr.(string)
+	return strings.EqualFold(k1.ToStr(), k2.ToStr())
+}
+
+// hashKey key在表中的位置，默认按字符串形式计算哈希，int 编码和字符串编码的相同值落在同一个bucket
+func (d *Dict) hashKey(key *Obj, mask int) int {
+	var b []byte
+	if d.KeyBytesFn != nil {
+		b = d.KeyBytesFn(key)
+	} else {
+		b = []byte(key.ToStr())
+	}
+	return int(d.HashFn(b) & uint64(mask))
+}
+
+func (d *Dict) dupKey(key *Obj) *Obj {
+	if d.KeyDupFn != nil {
+		return d.KeyDupFn(key)
+	}
+	key.incrRefCount()
+	return key
+}
+
+// dupVal set 等不需要value的dict中 val 为 nil
+func (d *Dict) dupVal(val *Obj) *Obj {
+	if val == nil {
+		return nil
+	}
+	if d.ValDupFn != nil {
+		return d.ValDupFn(val)
+	}
+	val.incrRefCount()
+	return val
+}
+
+func (d *Dict) freeKey(key *Obj) {
+	if d.KeyDestructorFn != nil {
+		d.KeyDestructorFn(key)
+		return
+	}
+	key.decrRefCount()
+}
+
+func (d *Dict) freeVal(val *Obj) {
+	if val == nil {
+		return
+	}
+	if d.ValDestructorFn != nil {
+		d.ValDestructorFn(val)
+		return
+	}
+	val.decrRefCount()
 }
 
 func NewDict(dictType DictType) *Dict {
@@ -77,34 +151,296 @@ func newHTable(size int) *hTable {
 	}
 }
 
+// RandomGet 随机返回一个元素，dict为空时返回 nil
+// 随机选择非空bucket再从链表中随机选择，链表长度不同时不完全均匀，需要更均匀时用 FairRandomGet
 func (d *Dict) RandomGet() *hEntry {
-	bucketNum := 0
-	if d.ht[0] == nil {
+	if d.Len() == 0 {
 		return nil
 	}
-	if d.isRehash() && d.ht[1].used > d.ht[0].used {
-		bucketNum = 1
+	d.rehashStep()
+	var head *hEntry
+	if d.isRehash() {
+		// ht[0] 中 rehashIdx 之前的bucket已经迁移，一定为空
+		size0 := d.ht[0].size
+		for head == nil {
+			idx := d.rehashIdx + rand.Intn(size0+d.ht[1].size-d.rehashIdx)
+			if idx >= size0 {
+				head = d.ht[1].entries[idx-size0]
+			} else {
+				head = d.ht[0].entries[idx]
+			}
+		}
+	} else {
+		for head == nil {
+			head = d.ht[0].entries[rand.Intn(d.ht[0].size)]
+		}
+	}
+	n := 0
+	for cur := head; cur != nil; cur = cur.next {
+		n++
+	}
+	th := rand.Intn(n)
+	for ; th > 0; th-- {
+		head = head.next
+	}
+	return head
+}
+
+// GetSomeKeys 从随机位置开始连续遍历bucket，返回最多 count 个元素，不保证随机分布和不重复
+// 比调用 count 次 RandomGet 快得多，用于抽样
+func (d *Dict) GetSomeKeys(count int) []*hEntry {
+	if n := d.Len(); count > n {
+		count = n
 	}
-	bucket := d.ht[bucketNum]
-	if bucket.used == 0 {
+	if count == 0 {
 		return nil
 	}
-	th := rand.Intn(bucket.used)
+	// 顺带推进rehash
+	for i := 0; i < count; i++ {
+		if !d.isRehash() {
+			break
+		}
+		d.rehashStep()
+	}
+	tables := 1
+	maxSizeMask := d.ht[0].mask
+	if d.isRehash() {
+		tables = 2
+		if d.ht[1].mask > maxSizeMask {
+			maxSizeMask = d.ht[1].mask
+		}
+	}
 
-	// 寻找 bucket里面 第th个entry
-	id := 0
-	for i := 0; i < len(bucket.entries); i++ {
-		if bucket.entries[i] == nil {
-			continue
+	entries := make([]*hEntry, 0, count)
+	maxSteps := count * 10
+	emptyLen := 0 // 连续空bucket的个数
+	i := rand.Int() & maxSizeMask
+	for ; len(entries) < count && maxSteps > 0; maxSteps-- {
+		for j := 0; j < tables; j++ {
+			// 已迁移的bucket为空，小表越界时跳过
+			if tables == 2 && j == 0 && i < d.rehashIdx {
+				// i 超出 ht[1] 时两张表在 rehashIdx 之前都没有元素，直接跳过去
+				if i >= d.ht[1].size {
+					i = d.rehashIdx
+				} else {
+					continue
+				}
+			}
+			if i >= d.ht[j].size {
+				continue
+			}
+			head := d.ht[j].entries[i]
+			if head == nil {
+				emptyLen++
+				// 连续空bucket太多时换个随机位置
+				if emptyLen >= 5 && emptyLen > count {
+					i = rand.Int() & maxSizeMask
+					emptyLen = 0
+				}
+				continue
+			}
+			emptyLen = 0
+			for cur := head; cur != nil && len(entries) < count; cur = cur.next {
+				entries = append(entries, cur)
+			}
 		}
-		for cur := bucket.entries[i]; cur != nil; cur = cur.next {
-			if id == th {
-				return cur
+		i = (i + 1) & maxSizeMask
+	}
+	return entries
+}
+
+// fairRandomSamples FairRandomGet 抽样的元素个数
+const fairRandomSamples = 15
+
+// FairRandomGet 先用 GetSomeKeys 抽样再从中随机选择，避免长链表中的元素被选中的概率偏低
+func (d *Dict) FairRandomGet() *hEntry {
+	entries := d.GetSomeKeys(fairRandomSamples)
+	if len(entries) == 0 {
+		return d.RandomGet()
+	}
+	return entries[rand.Intn(len(entries))]
+}
+
+// Release 释放全部元素的引用并清空dict
+func (d *Dict) Release() {
+	d.rangeReadOnly(func(key, val *Obj) bool {
+		d.freeKey(key)
+		d.freeVal(val)
+		return true
+	})
+	d.ht = [2]*hTable{}
+	d.rehashIdx = -1
+}
+
+// Len 元素个数
+func (d *Dict) Len() int {
+	n := 0
+	for i := 0; i <= 1; i++ {
+		if d.ht[i] != nil {
+			n += d.ht[i].used
+		}
+	}
+	return n
+}
+
+// Range 遍历全部元素，fn 返回 false 时停止，遍历过程中不能修改dict
+// 遍历期间暂停rehash，fn 中可以查询dict (Get/Exists 会推进rehash)
+func (d *Dict) Range(fn func(key, val *Obj) bool) {
+	d.rangeWith(d.GetSafeIterator(), fn)
+}
+
+// rangeReadOnly 使用非安全迭代器遍历，fn 中不能访问dict，用于确定只读的内部遍历
+func (d *Dict) rangeReadOnly(fn func(key, val *Obj) bool) {
+	d.rangeWith(d.GetIterator(), fn)
+}
+
+func (d *Dict) rangeWith(it *DictIterator, fn func(key, val *Obj) bool) {
+	defer it.Release()
+	for entry := it.Next(); entry != nil; entry = it.Next() {
+		if !fn(entry.key, entry.val) {
+			return
+		}
+	}
+}
+
+// DictIterator 按 bucket 顺序遍历 ht[0]，rehash 中再遍历 ht[1]
+// 安全迭代器存活期间暂停rehash，可以删除 Next 返回的元素或者新增元素(新增的元素不一定会被遍历到)
+// 非安全迭代器不暂停rehash，期间只能读，Release 时校验dict没有被修改
+type DictIterator struct {
+	d           *Dict
+	table       int
+	index       int
+	safe        bool
+	started     bool
+	entry       *hEntry
+	nextEntry   *hEntry // entry 可能在返回后被删除，提前取出下一个
+	fingerprint uint64
+}
+
+// GetIterator 非安全迭代器，遍历期间不能修改dict，包括 Get 等会推进rehash的操作
+func (d *Dict) GetIterator() *DictIterator {
+	return &DictIterator{d: d, index: -1}
+}
+
+// GetSafeIterator 安全迭代器，遍历期间可以修改dict
+func (d *Dict) GetSafeIterator() *DictIterator {
+	it := d.GetIterator()
+	it.safe = true
+	return it
+}
+
+// Next 返回下一个元素，遍历结束返回 nil
+func (it *DictIterator) Next() *hEntry {
+	for {
+		if it.entry == nil {
+			if !it.started {
+				it.started = true
+				if it.safe {
+					it.d.pauseRehash++
+				} else {
+					it.fingerprint = it.d.fingerprint()
+				}
+			}
+			ht := it.d.ht[it.table]
+			if ht == nil {
+				return nil
+			}
+			it.index++
+			if it.index >= ht.size {
+				if it.table == 1 || !it.d.isRehash() {
+					return nil
+				}
+				it.table++
+				it.index = 0
+				ht = it.d.ht[1]
 			}
-			id++
+			it.entry = ht.entries[it.index]
+		} else {
+			it.entry = it.nextEntry
+		}
+		if it.entry != nil {
+			it.nextEntry = it.entry.next
+			return it.entry
 		}
 	}
-	return nil
+}
+
+// Release 结束遍历，安全迭代器恢复rehash，非安全迭代器检查遍历期间dict是否被修改
+func (it *DictIterator) Release() {
+	if !it.started {
+		return
+	}
+	if it.safe {
+		it.d.pauseRehash--
+	} else if it.fingerprint != it.d.fingerprint() {
+		panic("dict modified during unsafe iteration")
+	}
+	it.started = false
+}
+
+// fingerprint 两张表的地址、大小和元素个数的哈希，非安全迭代期间dict被修改时会变化
+func (d *Dict) fingerprint() uint64 {
+	var integers [6]uint64
+	for i := 0; i <= 1; i++ {
+		if ht := d.ht[i]; ht != nil {
+			integers[i*3] = uint64(uintptr(unsafe.Pointer(ht)))
+			integers[i*3+1] = uint64(ht.size)
+			integers[i*3+2] = uint64(ht.used)
+		}
+	}
+	// Tomas Wang 64位整数哈希，依次混入，顺序不同结果不同
+	hash := uint64(0)
+	for _, v := range integers {
+		hash += v
+		hash = ^hash + hash<<21
+		hash ^= hash >> 24
+		hash = hash + hash<<3 + hash<<8
+		hash ^= hash >> 14
+		hash = hash + hash<<2 + hash<<4
+		hash ^= hash >> 28
+		hash += hash << 31
+	}
+	return hash
+}
+
+// Scan 从 cursor 开始遍历一个bucket，返回下次的游标，返回 0 表示遍历结束
+// 游标按反向二进制递增 (高位加1)，扩容/缩容后已遍历过的bucket对应的新bucket也都在游标之前，
+// 因此遍历期间一直存在的元素都会被返回，但可能重复返回
+// rehash 过程中两张表都要遍历：先遍历小表的 bucket，再遍历大表中由它展开的所有 bucket
+// fn 中不能修改dict
+func (d *Dict) Scan(cursor uint64, fn func(key, val *Obj)) uint64 {
+	if d.Len() == 0 {
+		return 0
+	}
+	scanBucket := func(ht *hTable, idx uint64) {
+		for cur := ht.entries[idx]; cur != nil; cur = cur.next {
+			fn(cur.key, cur.val)
+		}
+	}
+	if !d.isRehash() {
+		m0 := uint64(d.ht[0].mask)
+		scanBucket(d.ht[0], cursor&m0)
+		// 掩码外的位置1，反转后加1再反转回来，相当于高位加1
+		cursor |= ^m0
+		return bits.Reverse64(bits.Reverse64(cursor) + 1)
+	}
+
+	t0, t1 := d.ht[0], d.ht[1]
+	if t0.size > t1.size {
+		t0, t1 = t1, t0
+	}
+	m0, m1 := uint64(t0.mask), uint64(t1.mask)
+	scanBucket(t0, cursor&m0)
+	for {
+		scanBucket(t1, cursor&m1)
+		cursor |= ^m1
+		cursor = bits.Reverse64(bits.Reverse64(cursor) + 1)
+		// 大表中高出小表掩码的位全部遍历完后结束
+		if cursor&(m0^m1) == 0 {
+			break
+		}
+	}
+	return cursor
 }
 
 func (d *Dict) Get(key *Obj) *Obj {
@@ -117,14 +453,23 @@ func (d *Dict) Get(key *Obj) *Obj {
 	return d.get(key, 0)
 }
 
+// Exists key是否存在，val 可能为 nil (如 set)
+func (d *Dict) Exists(key *Obj) bool {
+	d.expandIfNeed()
+	if d.isRehash() && d.find(key, 1) != nil {
+		return true
+	}
+	return d.find(key, 0) != nil
+}
+
 func (d *Dict) Add(key, val *Obj) error {
 	d.expandIfNeed()
 	if d.isRehash() {
-		if v := d.get(key, 1); v != nil {
+		if entry := d.find(key, 1); entry != nil {
 			return errorExist
 		}
 	}
-	if v := d.get(key, 0); v != nil {
+	if entry := d.find(key, 0); entry != nil {
 		return errorExist
 	}
 	d.add(key, val)
@@ -134,67 +479,87 @@ func (d *Dict) Add(key, val *Obj) error {
 func (d *Dict) Set(key, val *Obj) error {
 	d.expandIfNeed()
 
-	var oldV *Obj
-	bucketNum := 0
-
-	if oldV = d.get(key, 0); oldV != nil {
-		bucketNum = 0
-	} else if d.isRehash() {
-		if oldV = d.get(key, 1); oldV != nil {
-			bucketNum = 1
-		}
+	entry := d.find(key, 0)
+	if entry == nil && d.isRehash() {
+		entry = d.find(key, 1)
 	}
-	if oldV == nil {
+	if entry == nil {
 		return errorNotFound
 	}
 
-	d.set(key, val, oldV, bucketNum)
+	d.set(entry, val)
 	return nil
 }
 
 func (d *Dict) Del(key *Obj) bool {
+	entry := d.Unlink(key)
+	if entry == nil {
+		return false
+	}
+	d.FreeUnlinkedEntry(entry)
+	return true
+}
+
+// Unlink 从dict中摘下元素但不释放，调用方用完后调用 FreeUnlinkedEntry，或者接管key/val的引用
+func (d *Dict) Unlink(key *Obj) *hEntry {
 	d.expandIfNeed()
 
-	exist := false
+	var found *hEntry
 	for i := 0; i <= 1; i++ { // 这种写法不错
-		idx := d.HashFn(key) & d.ht[i].mask
+		idx := d.hashKey(key, d.ht[i].mask)
 		cur := d.ht[i].entries[idx]
 		var pre *hEntry
 		for cur != nil {
 			next := cur.next
 			if d.EqualFn(cur.key, key) {
-				exist = true
-				cur.val.decrRefCount()
-				cur.key.decrRefCount()
+				found = cur
+				d.ht[i].used--
 				if pre == nil {
 					d.ht[i].entries[idx] = next
-					break
 				} else {
 					pre.next = next
-					break
 				}
+				cur.next = nil
+				break
 			}
 			pre = cur
 			cur = next
 		}
-		if !d.isRehash() {
+		if found != nil || !d.isRehash() {
 			break
 		}
 	}
-	return exist
+	if found != nil {
+		d.shrinkIfNeed()
+	}
+	return found
+}
+
+// FreeUnlinkedEntry 释放 Unlink 摘下的元素
+func (d *Dict) FreeUnlinkedEntry(entry *hEntry) {
+	d.freeVal(entry.val)
+	d.freeKey(entry.key)
 }
 
-func (d *Dict) set(key, newVal, oldVal *Obj, bucketNum int) {
-	oldVal.decrRefCount() // help go gc
-	oldVal.ptr = newVal.ptr
-	oldVal.incrRefCount()
+// 替换entry的val，val可能是共享对象，不能原地修改旧val
+func (d *Dict) set(entry *hEntry, val *Obj) {
+	old := entry.val
+	entry.val = d.dupVal(val)
+	d.freeVal(old) // help go gc
 }
 
 func (d *Dict) get(key *Obj, bucketNum int) *Obj {
-	idx := d.HashFn(key) & d.ht[bucketNum].mask
+	if entry := d.find(key, bucketNum); entry != nil {
+		return entry.val
+	}
+	return nil
+}
+
+func (d *Dict) find(key *Obj, bucketNum int) *hEntry {
+	idx := d.hashKey(key, d.ht[bucketNum].mask)
 	for cur := d.ht[bucketNum].entries[idx]; cur != nil; cur = cur.next {
 		if d.EqualFn(cur.key, key) {
-			return cur.val
+			return cur
 		}
 	}
 	return nil
@@ -206,22 +571,36 @@ func (d *Dict) add(key, val *Obj) {
 		bucketNum = 1
 	}
 
-	idx := d.HashFn(key) & d.ht[bucketNum].mask
+	idx := d.hashKey(key, d.ht[bucketNum].mask)
 	entry := &hEntry{
-		key:  key,
-		val:  val,
+		key:  d.dupKey(key),
+		val:  d.dupVal(val),
 		next: d.ht[bucketNum].entries[idx],
 	}
-	key.incrRefCount()
-	val.incrRefCount()
 
 	d.ht[bucketNum].entries[idx] = entry
 	d.ht[bucketNum].used++
 }
 
-// 是否需要扩容
+// 是否需要扩容，禁止resize时负载过高也要扩容
 func (d *Dict) needResize() bool {
-	return d.ht[0].used/d.ht[0].size >= loadFactor
+	ratio := d.ht[0].used / d.ht[0].size
+	if !dictCanResize {
+		return ratio > forceResizeRatio
+	}
+	return ratio >= loadFactor
+}
+
+// 是否需要缩容，填充率低于 hashtableMinFill%，禁止resize时阈值再除以 forceResizeRatio
+func (d *Dict) needShrink() bool {
+	ht := d.ht[0]
+	if ht == nil || ht.size <= initSize {
+		return false
+	}
+	if !dictCanResize {
+		return ht.used*100*forceResizeRatio < ht.size*hashtableMinFill
+	}
+	return ht.used*100 < ht.size*hashtableMinFill
 }
 
 // 是否正在扩容
@@ -234,57 +613,100 @@ func (d *Dict) nextExpandSize() int {
 	return d.ht[0].size << 1
 }
 
+// 缩容后的大小：能容纳全部元素的最小的2的幂，不小于 initSize
+func (d *Dict) nextShrinkSize() int {
+	size := initSize
+	for size <= d.ht[0].used {
+		size <<= 1
+	}
+	return size
+}
+
+// shrinkIfNeed 删除后元素太少时开始 rehash 到小表，正在 rehash 时等下次
+func (d *Dict) shrinkIfNeed() {
+	if d.isRehash() || !d.needShrink() {
+		return
+	}
+	d.startRehash(d.nextShrinkSize())
+	d.rehashStep()
+}
+
 func (d *Dict) expandIfNeed() {
 	// 如果需要扩容的话，rehash下
 	if d.ht[0] == nil {
 		d.ht[0] = newHTable(initSize)
 	} else if d.isRehash() {
-		d.expandStep()
+		d.rehashStep()
 	} else if d.needResize() {
-		sz := d.nextExpandSize()
-		d.ht[1] = newHTable(sz)
-		d.expandStep()
+		d.startRehash(d.nextExpandSize())
+		d.rehashStep()
 	}
 }
 
-// 扩容 d.ht[0] -> d.ht[1]
-func (d *Dict) expandStep() {
-	d.rehashIdx++
-	if d.rehashIdx >= len(d.ht[0].entries) || d.ht[0].used == 0 {
-		d.finishRehash()
-		return
+// rehashStep 读写时顺带迁移一个bucket，有安全迭代器时暂停
+func (d *Dict) rehashStep() {
+	if d.pauseRehash == 0 {
+		d.expandStep(1)
 	}
-	// 每次迁移一个bucket，其实可以考虑按阈值迁移
-	// 对于操作都是单操作的来说，不会出现已经在rehash的情况下，又需要rehash的情况
-	fromHt := d.ht[0]
-	toHt := d.ht[1]
+}
 
-	rehashNum := 0
-	for i := d.rehashIdx; ; i++ {
-		if i >= len(fromHt.entries) {
-			d.finishRehash()
-			return
-		}
-		if fromHt.entries[i] == nil {
-			continue
+// startRehash 创建新表，之后逐步把 d.ht[0] 迁移过去
+func (d *Dict) startRehash(size int) {
+	d.ht[1] = newHTable(size)
+	d.rehashIdx = 0
+}
+
+// expandStep rehash d.ht[0] -> d.ht[1]，扩容和缩容共用
+// 最多迁移 n 个bucket，为避免稀疏表耗时过长最多访问 n*10 个空bucket，返回是否还需要继续
+func (d *Dict) expandStep(n int) bool {
+	if !d.isRehash() {
+		return false
+	}
+	fromHt, toHt := d.ht[0], d.ht[1]
+	emptyVisits := n * 10
+	for ; n > 0 && fromHt.used > 0; n-- {
+		// used > 0 时 rehashIdx 之后一定还有非空bucket
+		for fromHt.entries[d.rehashIdx] == nil {
+			d.rehashIdx++
+			if emptyVisits--; emptyVisits == 0 {
+				return true
+			}
 		}
-		fromBucket := fromHt.entries[i]
-		cur := fromBucket
-		for cur != nil {
+		for cur := fromHt.entries[d.rehashIdx]; cur != nil; {
 			next := cur.next
-
-			newBucketId := d.HashFn(cur.key) & toHt.mask
+			newBucketId := d.hashKey(cur.key, toHt.mask)
 			cur.next = toHt.entries[newBucketId]
 			toHt.entries[newBucketId] = cur
-
 			toHt.used++
 			fromHt.used--
 			cur = next
-			rehashNum++
 		}
-		d.rehashIdx = i
-		break
+		fromHt.entries[d.rehashIdx] = nil
+		d.rehashIdx++
+	}
+	if fromHt.used == 0 {
+		d.finishRehash()
+		// rehash期间删除了较多元素时继续缩容
+		d.shrinkIfNeed()
+		return d.isRehash()
+	}
+	return true
+}
+
+// RehashMilliseconds 每次迁移100个bucket，直到rehash完成或耗时超过 ms，返回迁移的bucket数
+func (d *Dict) RehashMilliseconds(ms int) int {
+	if d.pauseRehash > 0 {
+		return 0
+	}
+	start := time.Now()
+	rehashes := 0
+	for d.expandStep(100) {
+		rehashes += 100
+		if time.Since(start) > time.Duration(ms)*time.Millisecond {
+			break
+		}
 	}
+	return rehashes
 }
 
 // 完成rehash
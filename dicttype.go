@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+)
+
+/*
+   预定义的 DictType，参考 redis server.c 中的各种 dictType
+   1. 字符串key：keyspace、hash/set/zset，命令表使用大小写不敏感的版本
+   2. int64 key：key 的 ptr 为 int64，如整数集合
+   3. []byte key：key 的 ptr 为 []byte，二进制安全，如 pub/sub 频道
+   4. 指针key：key 的 ptr 为指针，按地址比较，如 client 注册表
+*/
+
+var (
+	StrDictType     = DictType{HashFn: Hash, EqualFn: Equal}
+	CaseStrDictType = DictType{HashFn: CaseHash, EqualFn: CaseEqual}
+	Int64DictType   = DictType{HashFn: Hash, KeyBytesFn: int64KeyBytes, EqualFn: int64KeyEqual}
+	BytesDictType   = DictType{HashFn: Hash, KeyBytesFn: bytesKeyBytes, EqualFn: bytesKeyEqual}
+	PtrDictType     = DictType{HashFn: Hash, KeyBytesFn: ptrKeyBytes, EqualFn: ptrKeyEqual}
+)
+
+func int64KeyBytes(key *Obj) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(key.ptr.(int64)))
+	return b
+}
+
+func int64KeyEqual(k1, k2 *Obj) bool {
+	return k1.ptr.(int64) == k2.ptr.(int64)
+}
+
+func bytesKeyBytes(key *Obj) []byte {
+	return key.ptr.([]byte)
+}
+
+func bytesKeyEqual(k1, k2 *Obj) bool {
+	return bytes.Equal(k1.ptr.([]byte), k2.ptr.([]byte))
+}
+
+// ptrKeyBytes 按指针地址哈希，Go 的堆对象不会移动，地址在对象存活期间不变
+func ptrKeyBytes(key *Obj) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(reflect.ValueOf(key.ptr).Pointer()))
+	return b
+}
+
+func ptrKeyEqual(k1, k2 *Obj) bool {
+	return k1.ptr == k2.ptr
+}
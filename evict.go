@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"github.com/draymonders/gmem/ae"
+)
+
+/*
+   内存淘汰，参考 redis evict.c
+   1. 每个命令执行前检查内存，超过 maxmemory 时按策略淘汰key，淘汰不掉时拒绝 denyoom 命令
+   2. LRU: Obj.lru 记录最近访问时间(秒级时钟，24位)，按空闲时间淘汰
+   3. LFU: Obj.lru 高16位记录最近衰减时间(分钟)，低8位为对数计数器，按访问频率淘汰
+   4. 每次从各db抽样 maxmemory-samples 个key，放入按空闲程度排序的淘汰池，淘汰池中最空闲的key
+*/
+
+const (
+	maxmemoryFlag_LRU     = 1 << 0
+	maxmemoryFlag_LFU     = 1 << 1
+	maxmemoryFlag_AllKeys = 1 << 2
+	// 需要按key记录访问信息的策略不能使用共享整数
+	maxmemoryFlag_NoSharedIntegers = maxmemoryFlag_LRU | maxmemoryFlag_LFU
+
+	maxmemory_VolatileLRU    = 0<<8 | maxmemoryFlag_LRU
+	maxmemory_VolatileLFU    = 1<<8 | maxmemoryFlag_LFU
+	maxmemory_VolatileTTL    = 2 << 8
+	maxmemory_VolatileRandom = 3 << 8
+	maxmemory_AllKeysLRU     = 4<<8 | maxmemoryFlag_LRU | maxmemoryFlag_AllKeys
+	maxmemory_AllKeysLFU     = 5<<8 | maxmemoryFlag_LFU | maxmemoryFlag_AllKeys
+	maxmemory_AllKeysRandom  = 6<<8 | maxmemoryFlag_AllKeys
+	maxmemory_NoEviction     = 7 << 8
+)
+
+var maxmemoryPolicyNames = map[string]int{
+	"volatile-lru":    maxmemory_VolatileLRU,
+	"volatile-lfu":    maxmemory_VolatileLFU,
+	"volatile-ttl":    maxmemory_VolatileTTL,
+	"volatile-random": maxmemory_VolatileRandom,
+	"allkeys-lru":     maxmemory_AllKeysLRU,
+	"allkeys-lfu":     maxmemory_AllKeysLFU,
+	"allkeys-random":  maxmemory_AllKeysRandom,
+	"noeviction":      maxmemory_NoEviction,
+}
+
+// parseMaxmemoryPolicy 策略名 -> maxmemory_*
+func parseMaxmemoryPolicy(name string) (int, error) {
+	policy, ok := maxmemoryPolicyNames[name]
+	if !ok {
+		return 0, fmt.Errorf("invalid maxmemory-policy %q", name)
+	}
+	return policy, nil
+}
+
+func maxmemoryPolicyName(policy int) string {
+	for name, p := range maxmemoryPolicyNames {
+		if p == policy {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+/* ---------------- LRU ---------------- */
+
+const (
+	lruBits            = 24
+	lruClockMax        = 1<<lruBits - 1 // Obj.lru 能表示的最大时钟
+	lruClockResolution = 1000           // 时钟精度，单位ms
+)
+
+// getLRUClock 按当前时间计算LRU时钟，会回绕
+func getLRUClock() uint32 {
+	return uint32(ae.GetUnixTime()/lruClockResolution) & lruClockMax
+}
+
+// lruClock cron 频率高于时钟精度时使用 serverCron 缓存的时钟，省去取时间的开销
+func lruClock() uint32 {
+	if 1000/serverCronInterval <= lruClockResolution {
+		return server.lruclock
+	}
+	return getLRUClock()
+}
+
+// estimateObjectIdleTime 对象的空闲时间(ms)，时钟回绕时按一圈计算
+func estimateObjectIdleTime(obj *Obj) uint64 {
+	clock := lruClock()
+	if clock >= obj.lru {
+		return uint64(clock-obj.lru) * lruClockResolution
+	}
+	return uint64(clock+(lruClockMax-obj.lru)) * lruClockResolution
+}
+
+/* ---------------- LFU ---------------- */
+
+const lfuInitVal = 5 // 新对象的计数器初值，避免刚写入就被淘汰
+
+// lfuGetTimeInMinutes 分钟级时间，取低16位
+func lfuGetTimeInMinutes() uint32 {
+	return uint32(ae.GetUnixTime()/1000/60) & math.MaxUint16
+}
+
+// lfuTimeElapsed 距上次衰减经过的分钟数，处理回绕
+func lfuTimeElapsed(ldt uint32) uint32 {
+	now := lfuGetTimeInMinutes()
+	if now >= ldt {
+		return now - ldt
+	}
+	return math.MaxUint16 - ldt + now
+}
+
+// lfuLogIncr 对数递增，计数器越大递增概率越低，255 封顶
+func lfuLogIncr(counter uint32) uint32 {
+	if counter == 255 {
+		return counter
+	}
+	baseval := float64(counter) - lfuInitVal
+	if baseval < 0 {
+		baseval = 0
+	}
+	p := 1.0 / (baseval*float64(server.lfuLogFactor) + 1)
+	if rand.Float64() < p {
+		counter++
+	}
+	return counter
+}
+
+// lfuDecrAndReturn 按经过的衰减周期数减小计数器，只返回结果不修改对象
+func lfuDecrAndReturn(obj *Obj) uint32 {
+	ldt := obj.lru >> 8
+	counter := obj.lru & 255
+	var periods uint32
+	if server.lfuDecayTime > 0 {
+		periods = lfuTimeElapsed(ldt) / uint32(server.lfuDecayTime)
+	}
+	if periods >= counter {
+		return 0
+	}
+	return counter - periods
+}
+
+// updateLFU 访问时先衰减再递增计数器
+func updateLFU(obj *Obj) {
+	counter := lfuDecrAndReturn(obj)
+	counter = lfuLogIncr(counter)
+	obj.lru = lfuGetTimeInMinutes()<<8 | counter
+}
+
+// initObjectLRU 新建对象的 lru 字段
+func initObjectLRU() uint32 {
+	if server.maxmemoryPolicy&maxmemoryFlag_LFU != 0 {
+		return lfuGetTimeInMinutes()<<8 | lfuInitVal
+	}
+	return lruClock()
+}
+
+// updateObjectAccess 命令访问key时更新访问信息，共享对象不记录
+func updateObjectAccess(obj *Obj) {
+	if obj.isShared() {
+		return
+	}
+	if server.maxmemoryPolicy&maxmemoryFlag_LFU != 0 {
+		updateLFU(obj)
+	} else {
+		obj.lru = lruClock()
+	}
+}
+
+/* ---------------- 淘汰池 ---------------- */
+
+const evpoolSize = 16
+
+// evictionPoolEntry idle 越大越应该被淘汰
+type evictionPoolEntry struct {
+	idle uint64
+	key  string
+	dbid int
+}
+
+// evictionPool 按 idle 升序排列，最多 evpoolSize 个，跨多次淘汰保留
+var evictionPool []evictionPoolEntry
+
+// evictionPoolPopulate 从 sampleDict 抽样，按策略计算 idle 后放入淘汰池
+// sampleDict 为 db.dict 或 db.expires，value 总是从 db.dict 中取
+func evictionPoolPopulate(db *DB, sampleDict *Dict) {
+	for _, entry := range sampleDict.GetSomeKeys(server.maxmemorySamples) {
+		val := entry.val
+		if sampleDict != db.dict {
+			if val = db.dict.Get(entry.key); val == nil {
+				continue
+			}
+		}
+		var idle uint64
+		switch {
+		case server.maxmemoryPolicy&maxmemoryFlag_LRU != 0:
+			idle = estimateObjectIdleTime(val)
+		case server.maxmemoryPolicy&maxmemoryFlag_LFU != 0:
+			idle = 255 - uint64(lfuDecrAndReturn(val))
+		case server.maxmemoryPolicy == maxmemory_VolatileTTL:
+			// 越早过期越先淘汰
+			idle = math.MaxUint64 - uint64(entry.val.ptr.(int64))
+		}
+		evictionPoolInsert(evictionPoolEntry{idle: idle, key: entry.key.ToStr(), dbid: db.id})
+	}
+}
+
+func evictionPoolInsert(e evictionPoolEntry) {
+	for _, old := range evictionPool {
+		if old.key == e.key && old.dbid == e.dbid {
+			return
+		}
+	}
+	k := sort.Search(len(evictionPool), func(i int) bool { return evictionPool[i].idle >= e.idle })
+	if len(evictionPool) < evpoolSize {
+		evictionPool = append(evictionPool, evictionPoolEntry{})
+		copy(evictionPool[k+1:], evictionPool[k:])
+		evictionPool[k] = e
+		return
+	}
+	// 池满且比所有候选都不空闲，丢弃
+	if k == 0 {
+		return
+	}
+	// 池满时挤掉最不空闲的第一个
+	copy(evictionPool[:k-1], evictionPool[1:k])
+	evictionPool[k-1] = e
+}
+
+// evictionPoolPop 从池尾取最应淘汰且仍然存在的key
+func evictionPoolPop() (*DB, *Obj) {
+	for len(evictionPool) > 0 {
+		e := evictionPool[len(evictionPool)-1]
+		evictionPool = evictionPool[:len(evictionPool)-1]
+		if e.dbid >= len(server.dbs) {
+			continue
+		}
+		db := server.dbs[e.dbid]
+		key := NewObjectFromStr(e.key)
+		dict := db.dict
+		if server.maxmemoryPolicy&maxmemoryFlag_AllKeys == 0 {
+			dict = db.expires
+		}
+		// 抽样之后key可能已被删除或者去掉了过期时间
+		if dict.Get(key) != nil {
+			return db, key
+		}
+	}
+	return nil, nil
+}
+
+var nextEvictRandomDb int // random 策略轮流从各db淘汰
+
+// evictSelectKey 按策略选出下一个要淘汰的key，没有可淘汰的key时返回 nil
+func evictSelectKey() (*DB, *Obj) {
+	policy := server.maxmemoryPolicy
+	if policy&(maxmemoryFlag_LRU|maxmemoryFlag_LFU) != 0 || policy == maxmemory_VolatileTTL {
+		for {
+			total := 0
+			for _, db := range server.dbs {
+				dict := db.dict
+				if policy&maxmemoryFlag_AllKeys == 0 {
+					dict = db.expires
+				}
+				if dict.Len() > 0 {
+					evictionPoolPopulate(db, dict)
+					total += dict.Len()
+				}
+			}
+			if total == 0 {
+				return nil, nil
+			}
+			if db, key := evictionPoolPop(); key != nil {
+				return db, key
+			}
+		}
+	}
+	// random 策略
+	for i := 0; i < len(server.dbs); i++ {
+		db := server.dbs[nextEvictRandomDb%len(server.dbs)]
+		nextEvictRandomDb++
+		dict := db.dict
+		if policy&maxmemoryFlag_AllKeys == 0 {
+			dict = db.expires
+		}
+		if entry := dict.RandomGet(); entry != nil {
+			key := entry.key
+			key.incrRefCount()
+			return db, key
+		}
+	}
+	return nil, nil
+}
+
+const (
+	evictOk      = 0 // 内存未超限或已淘汰到限制以下
+	evictRunning = 1 // 超时退出，serverCron 中继续淘汰
+	evictFail    = 2 // 没有可淘汰的key或策略不允许淘汰
+
+	evictionTimeLimit = 500 * time.Microsecond // 单次淘汰的耗时上限
+)
+
+// performEvictions 内存超过 maxmemory 时淘汰key，直到释放的内存足够
+func performEvictions() int {
+	if server.maxmemory == 0 {
+		return evictOk
+	}
+	used := usedMemory()
+	if used <= server.maxmemory {
+		return evictOk
+	}
+	if server.maxmemoryPolicy == maxmemory_NoEviction {
+		return evictFail
+	}
+	toFree := used - server.maxmemory
+	freed := int64(0)
+	start := time.Now()
+	for keys := 1; freed < toFree; keys++ {
+		db, key := evictSelectKey()
+		if key == nil {
+			return evictFail
+		}
+		delta := usedMemory()
+		dbGenericDelete(db, key, server.lazyfreeLazyEviction)
+		key.decrRefCount()
+		delta -= usedMemory()
+		freed += delta
+		server.statEvictedKeys++
+		if keys%16 == 0 && time.Since(start) > evictionTimeLimit {
+			return evictRunning
+		}
+	}
+	return evictOk
+}
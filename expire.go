@@ -0,0 +1,111 @@
+package main
+
+import (
+	"time"
+
+	"github.com/draymonders/gmem/ae"
+)
+
+/*
+   主动过期：定期从 db.expires 抽样删除过期key，参考 redis activeExpireCycle
+   1. slow cycle 在 serverCron 中执行，耗时不超过 cron 周期的 25%
+   2. fast cycle 在 beforeSleep 中执行，耗时不超过 1ms，只在上轮因超时退出或过期比例偏高时执行
+   3. 每轮抽样 20 个key，过期比例高于 10% 时继续抽样
+*/
+
+const (
+	activeExpireCycleKeysPerLoop     = 20   // 每轮抽样的key个数
+	activeExpireCycleFastDuration    = 1000 // fast cycle 耗时上限，单位us
+	activeExpireCycleSlowTimePerc    = 25   // slow cycle 占 cron 周期的CPU比例
+	activeExpireCycleAcceptableStale = 10   // 可接受的过期key比例(%)，低于它就停止抽样
+
+	activeExpireCycle_Slow = 0
+	activeExpireCycle_Fast = 1
+)
+
+var (
+	lastFastCycle   time.Time // 上次 fast cycle 开始时间
+	timelimitExit   bool      // 上次 cycle 是否因超时退出
+	expireCurrentDb int       // 下次从哪个db开始，超时退出时下次接着处理
+)
+
+// activeExpireCycleTryExpire entry 已过期时删除，返回是否删除
+func activeExpireCycleTryExpire(db *DB, entry *hEntry, now int64) bool {
+	if now <= entry.val.ptr.(int64) {
+		return false
+	}
+	// 删除过程中 entry.key 可能被释放
+	key := entry.key
+	key.incrRefCount()
+	dbGenericDelete(db, key, server.lazyfreeLazyExpire)
+	key.decrRefCount()
+	server.statExpiredKeys++
+	return true
+}
+
+func activeExpireCycle(cycleType int) {
+	start := time.Now()
+	if cycleType == activeExpireCycle_Fast {
+		// 上轮没有超时且过期比例不高，说明没有积压
+		if !timelimitExit && server.statExpiredStalePerc < activeExpireCycleAcceptableStale {
+			return
+		}
+		// 两次 fast cycle 间隔至少 2 倍时长
+		if start.Sub(lastFastCycle) < 2*activeExpireCycleFastDuration*time.Microsecond {
+			return
+		}
+		lastFastCycle = start
+	}
+
+	timelimit := time.Duration(activeExpireCycleSlowTimePerc*serverCronInterval*1000/100) * time.Microsecond
+	if cycleType == activeExpireCycle_Fast {
+		timelimit = activeExpireCycleFastDuration * time.Microsecond
+	}
+	timelimitExit = false
+
+	totalSampled, totalExpired := 0, 0
+	for j := 0; j < len(server.dbs) && !timelimitExit; j++ {
+		db := server.dbs[expireCurrentDb%len(server.dbs)]
+		expireCurrentDb++
+		for iteration := 1; ; iteration++ {
+			num := db.expires.Len()
+			if num == 0 {
+				break
+			}
+			if num > activeExpireCycleKeysPerLoop {
+				num = activeExpireCycleKeysPerLoop
+			}
+			now := ae.GetUnixTime()
+			sampled, expired := 0, 0
+			for i := 0; i < num; i++ {
+				entry := db.expires.RandomGet()
+				if entry == nil {
+					break
+				}
+				sampled++
+				if activeExpireCycleTryExpire(db, entry, now) {
+					expired++
+				}
+			}
+			totalSampled += sampled
+			totalExpired += expired
+
+			// 每 16 轮检查一次耗时
+			if iteration%16 == 0 && time.Since(start) > timelimit {
+				timelimitExit = true
+				server.statExpiredTimeCapReachedCount++
+				break
+			}
+			if sampled == 0 || expired*100/sampled <= activeExpireCycleAcceptableStale {
+				break
+			}
+		}
+	}
+
+	// 过期比例的滑动平均
+	currentPerc := 0.0
+	if totalSampled > 0 {
+		currentPerc = float64(totalExpired) * 100 / float64(totalSampled)
+	}
+	server.statExpiredStalePerc = currentPerc*0.05 + server.statExpiredStalePerc*0.95
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"fmt"
+	"hash/crc32"
 	"net"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/draymonders/gmem/ae"
+	"golang.org/x/sys/unix"
 )
 
 //func Test_Epoll(t *testing.T) {
@@ -144,7 +151,7 @@ func Test_DictExpand(t *testing.T) {
 	}
 
 	for dict.isRehash() {
-		dict.expandStep()
+		dict.expandStep(1)
 	}
 
 	t.Logf("used %v", dict.ht[0].used)
@@ -160,3 +167,2119 @@ func Test_DictExpand(t *testing.T) {
 		t.Logf("entry key: %v val: %v", entry.key.ToStr(), entry.val.ToStr())
 	}
 }
+
+func Test_DictShrink(t *testing.T) {
+	defer DictSetResizeEnabled(true)
+	fill := func(dict *Dict, n int) {
+		for i := 0; i < n; i++ {
+			v := strconv.Itoa(i)
+			_ = dict.Add(NewObjectFromStr(v), NewObjectFromStr(v))
+		}
+		for dict.isRehash() {
+			dict.expandStep(1)
+		}
+	}
+	// 删到只剩 keep 个，rehash 完成后检查表大小和元素
+	deleteTo := func(dict *Dict, n, keep int) {
+		for i := keep; i < n; i++ {
+			if !dict.Del(NewObjectFromStr(strconv.Itoa(i))) {
+				t.Logf("del %d expect success", i)
+				t.FailNow()
+			}
+		}
+		for dict.isRehash() {
+			dict.expandStep(1)
+		}
+		if dict.Len() != keep || dict.ht[0].used != keep {
+			t.Logf("expect %d keys left, but Len %d used %d", keep, dict.Len(), dict.ht[0].used)
+			t.FailNow()
+		}
+		for i := 0; i < keep; i++ {
+			if v := dict.Get(NewObjectFromStr(strconv.Itoa(i))); v == nil || v.ToStr() != strconv.Itoa(i) {
+				t.Logf("expect key %d kept, but got %v", i, v)
+				t.FailNow()
+			}
+		}
+	}
+
+	dict := NewDict(DictType{HashFn: Hash, EqualFn: Equal})
+	fill(dict, 10000)
+	if dict.ht[0].size != 16384 {
+		t.Logf("expect size 16384, but got %d", dict.ht[0].size)
+		t.FailNow()
+	}
+	// 填充率低于 10% 时缩容到能容纳剩余元素的最小表，rehash 完成后仍需缩容时继续缩小
+	deleteTo(dict, 10000, 100)
+	if dict.ht[0].size != 128 {
+		t.Logf("expect shrink to 128, but got %d", dict.ht[0].size)
+		t.FailNow()
+	}
+	deleteTo(dict, 100, 0)
+	if dict.ht[0].size != initSize {
+		t.Logf("expect shrink to %d, but got %d", initSize, dict.ht[0].size)
+		t.FailNow()
+	}
+
+	// 禁止resize时，负载超过 forceResizeRatio 才扩容，填充率极低才缩容
+	DictSetResizeEnabled(false)
+	dict = NewDict(DictType{HashFn: Hash, EqualFn: Equal})
+	fill(dict, 1000)
+	if size := dict.ht[0].size; size != 256 {
+		t.Logf("expect size 256 with resize disabled, but got %d", size)
+		t.FailNow()
+	}
+	deleteTo(dict, 1000, 10)
+	if size := dict.ht[0].size; size != 256 {
+		t.Logf("expect no shrink with resize disabled, but got %d", size)
+		t.FailNow()
+	}
+	deleteTo(dict, 10, 4)
+	if size := dict.ht[0].size; size != initSize {
+		t.Logf("expect forced shrink, but got %d", size)
+		t.FailNow()
+	}
+
+	// 重新打开后正常缩容
+	DictSetResizeEnabled(true)
+	fill(dict, 1000)
+	deleteTo(dict, 1000, 10)
+	if size := dict.ht[0].size; size != initSize {
+		t.Logf("expect shrink after resize enabled, but got %d", size)
+		t.FailNow()
+	}
+}
+
+func Test_ActiveRehash(t *testing.T) {
+	newTestClient()
+	db := server.dbs[3]
+	for i := 0; i < 1000; i++ {
+		v := strconv.Itoa(i)
+		_ = db.dict.Add(NewObjectFromStr(v), NewObjectFromStr(v))
+	}
+	// 只迁移固定数量的bucket
+	for db.dict.isRehash() {
+		idx := db.dict.rehashIdx
+		db.dict.expandStep(4)
+		if db.dict.isRehash() && db.dict.rehashIdx > idx+4*10 {
+			t.Logf("expect at most %d buckets visited, but got %d", 4*10, db.dict.rehashIdx-idx)
+			t.FailNow()
+		}
+	}
+	// 没有读写时由 serverCron 推进rehash
+	db.dict.startRehash(db.dict.nextExpandSize())
+	setExpire(db, NewObjectFromStr("0"), ae.GetUnixTime()+100000)
+	db.expires.startRehash(initSize * 2)
+	for i := 0; i < 100 && (db.dict.isRehash() || db.expires.isRehash()); i++ {
+		activeRehashCycle()
+	}
+	if db.dict.isRehash() || db.expires.isRehash() {
+		t.Logf("expect rehash finished by cron")
+		t.FailNow()
+	}
+	if db.dict.Len() != 1000 || db.dict.ht[0].size != 2048 {
+		t.Logf("expect 1000 keys in size 2048, but got %d keys in size %d", db.dict.Len(), db.dict.ht[0].size)
+		t.FailNow()
+	}
+	for i := 0; i < 1000; i++ {
+		if db.dict.Get(NewObjectFromStr(strconv.Itoa(i))) == nil {
+			t.Logf("expect key %d exists", i)
+			t.FailNow()
+		}
+	}
+}
+
+func Test_DictIterator(t *testing.T) {
+	n := 1000
+	dict := NewDict(DictType{HashFn: Hash, EqualFn: Equal})
+	for i := 0; i < n; i++ {
+		v := strconv.Itoa(i)
+		_ = dict.Add(NewObjectFromStr(v), NewObjectFromStr(v))
+	}
+	for dict.isRehash() {
+		dict.expandStep(1)
+	}
+	dict.startRehash(dict.nextExpandSize())
+	dict.expandStep(100)
+
+	// 安全迭代器：rehash 中遍历并删除偶数key，每个key只返回一次，期间rehash暂停
+	seen := make(map[string]int)
+	it := dict.GetSafeIterator()
+	idx := dict.rehashIdx
+	for entry := it.Next(); entry != nil; entry = it.Next() {
+		key := entry.key.ToStr()
+		seen[key]++
+		if v, _ := strconv.Atoi(key); v%2 == 0 {
+			dict.Del(entry.key)
+		}
+		dict.Get(NewObjectFromStr("1"))
+	}
+	if dict.rehashIdx != idx {
+		t.Logf("expect rehash paused at %d, but got %d", idx, dict.rehashIdx)
+		t.FailNow()
+	}
+	it.Release()
+	if len(seen) != n {
+		t.Logf("expect %d keys visited, but got %d", n, len(seen))
+		t.FailNow()
+	}
+	for key, cnt := range seen {
+		if cnt != 1 {
+			t.Logf("expect key %s visited once, but got %d", key, cnt)
+			t.FailNow()
+		}
+	}
+	if dict.Len() != n/2 {
+		t.Logf("expect %d keys left, but got %d", n/2, dict.Len())
+		t.FailNow()
+	}
+	dict.Get(NewObjectFromStr("1"))
+	if dict.isRehash() && dict.rehashIdx == idx {
+		t.Logf("expect rehash resumed after release")
+		t.FailNow()
+	}
+
+	// 非安全迭代器：只读遍历正常，修改后 Release 时 panic
+	cnt := 0
+	it = dict.GetIterator()
+	for entry := it.Next(); entry != nil; entry = it.Next() {
+		cnt++
+	}
+	it.Release()
+	if cnt != n/2 {
+		t.Logf("expect %d keys visited, but got %d", n/2, cnt)
+		t.FailNow()
+	}
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Logf("expect panic when dict modified during unsafe iteration")
+				t.FailNow()
+			}
+		}()
+		it := dict.GetIterator()
+		it.Next()
+		_ = dict.Add(NewObjectFromStr("new"), NewObjectFromStr("new"))
+		it.Release()
+	}()
+}
+
+func Test_DictRandom(t *testing.T) {
+	newDict := func(n int) *Dict {
+		dict := NewDict(DictType{HashFn: Hash, EqualFn: Equal})
+		for i := 0; i < n; i++ {
+			v := strconv.Itoa(i)
+			_ = dict.Add(NewObjectFromStr(v), NewObjectFromStr(v))
+		}
+		for dict.isRehash() {
+			dict.expandStep(1)
+		}
+		return dict
+	}
+	// 抽样结果都是dict中的元素，多次抽样能覆盖全部元素
+	check := func(name string, dict *Dict, n int, sample func() []*hEntry) {
+		seen := make(map[string]bool)
+		for i := 0; i < n*50 && len(seen) < n; i++ {
+			for _, entry := range sample() {
+				if dict.Get(entry.key) != entry.val {
+					t.Logf("%s: sampled key %s not in dict", name, entry.key.ToStr())
+					t.FailNow()
+				}
+				seen[entry.key.ToStr()] = true
+			}
+		}
+		if len(seen) != n {
+			t.Logf("%s: expect %d keys sampled, but got %d", name, n, len(seen))
+			t.FailNow()
+		}
+	}
+	one := func(fn func() *hEntry) func() []*hEntry {
+		return func() []*hEntry {
+			entry := fn()
+			if entry == nil {
+				t.Logf("expect entry not nil")
+				t.FailNow()
+			}
+			return []*hEntry{entry}
+		}
+	}
+
+	if dict := NewDict(DictType{HashFn: Hash, EqualFn: Equal}); dict.RandomGet() != nil ||
+		dict.FairRandomGet() != nil || len(dict.GetSomeKeys(5)) != 0 {
+		t.Logf("expect nothing sampled from empty dict")
+		t.FailNow()
+	}
+
+	n := 200
+	dict := newDict(n)
+	check("RandomGet", dict, n, one(dict.RandomGet))
+	check("FairRandomGet", dict, n, one(dict.FairRandomGet))
+	// 链表较长时只取前 count 个，count 取大一些保证能覆盖
+	check("GetSomeKeys", dict, n, func() []*hEntry { return dict.GetSomeKeys(16) })
+	if got := len(dict.GetSomeKeys(n * 2)); got != n {
+		t.Logf("expect GetSomeKeys capped to %d, but got %d", n, got)
+		t.FailNow()
+	}
+
+	// 扩容和缩容的rehash过程中，两张表的元素都能抽到
+	for _, size := range []int{dict.ht[0].size * 2, dict.ht[0].size / 2} {
+		dict = newDict(n)
+		dict.startRehash(size)
+		dict.expandStep(dict.ht[0].size / 8)
+		dict.pauseRehash++
+		check("RandomGet rehashing", dict, n, one(dict.RandomGet))
+		check("GetSomeKeys rehashing", dict, n, func() []*hEntry { return dict.GetSomeKeys(16) })
+		dict.pauseRehash--
+	}
+
+	// 稀疏的大表也能很快抽到
+	dict = newDict(10000)
+	for i := 3; i < 10000; i++ {
+		dict.Del(NewObjectFromStr(strconv.Itoa(i)))
+	}
+	dict.pauseRehash++
+	check("RandomGet sparse", dict, 3, one(dict.RandomGet))
+	dict.pauseRehash--
+}
+
+func Test_SipHash(t *testing.T) {
+	cases := []string{"", "a", "get", "GET", "Hello, World", "0123456789ABCDEFabcdef", "键名KEY"}
+	for _, cs := range cases {
+		if Hash([]byte(cs)) != Hash([]byte(cs)) {
+			t.Logf("expect %q hash stable", cs)
+			t.FailNow()
+		}
+		if got, want := CaseHash([]byte(cs)), Hash([]byte(strings.ToLower(cs))); got != want {
+			t.Logf("expect CaseHash(%q) == Hash(lower), but got %x != %x", cs, got, want)
+			t.FailNow()
+		}
+	}
+	// SipHash-1-3 参考向量：密钥 00..0f，输入为 00, 01, ... 共 n 个字节
+	var refKey [16]byte
+	for i := range refKey {
+		refKey[i] = byte(i)
+	}
+	vectors := []struct {
+		n    int
+		want uint64
+	}{
+		{0, 0xabac0158050fc4dc},
+		{1, 0xc9f49bf37d57ca93},
+		{7, 0xd3927d989bb11140},
+		{8, 0x369095118d299a8e},
+		{15, 0xd320d86d2a519956},
+		{16, 0xcc4fdd1a7d908b66},
+	}
+	for _, v := range vectors {
+		in := make([]byte, v.n)
+		for i := range in {
+			in[i] = byte(i)
+		}
+		if got := siphash(in, &refKey, false); got != v.want {
+			t.Logf("siphash len %d expect %016x, but got %016x", v.n, v.want, got)
+			t.FailNow()
+		}
+		// 不含字母时 nocase 结果相同
+		if got := siphash(in, &refKey, true); got != v.want {
+			t.Logf("nocase siphash len %d expect %016x, but got %016x", v.n, v.want, got)
+			t.FailNow()
+		}
+	}
+
+	// 密钥不同时哈希值不同，无法离线构造冲突
+	var seed [16]byte
+	for _, cs := range cases {
+		if siphash([]byte(cs), &seed, false) == Hash([]byte(cs)) {
+			t.Logf("expect %q hash depends on seed", cs)
+			t.FailNow()
+		}
+	}
+	if Hash([]byte("abc")) == Hash([]byte("abd")) || Hash([]byte("abc")) == CaseHash([]byte("ABD")) {
+		t.Logf("expect different keys hash differently")
+		t.FailNow()
+	}
+
+	// 命令名大小写不敏感
+	c := newTestClient()
+	for _, name := range []string{"SET", "set", "SeT"} {
+		if got := execCmd(c, name, "k", "v"); got != respOK {
+			t.Logf("%s expect OK, but got %q", name, got)
+			t.FailNow()
+		}
+	}
+	if got := execCmd(c, "NOSUCHCMD"); !strings.Contains(got, "not support") {
+		t.Logf("expect unknown command, but got %q", got)
+		t.FailNow()
+	}
+}
+
+func Test_DictTypes(t *testing.T) {
+	n := 1000
+	ptrs := make([]*int, n)
+	for i := range ptrs {
+		ptrs[i] = new(int)
+	}
+	cases := []struct {
+		name  string
+		dt    DictType
+		keyFn func(i int) *Obj
+	}{
+		{"int64", Int64DictType, func(i int) *Obj { return NewObject(GType_Str, int64(i)-int64(n)/2) }},
+		{"bytes", BytesDictType, func(i int) *Obj { return NewObject(0, []byte{byte(i), 0, byte(i >> 8), 0xff}) }},
+		{"ptr", PtrDictType, func(i int) *Obj { return NewObject(0, ptrs[i]) }},
+	}
+	for _, cs := range cases {
+		dict := NewDict(cs.dt)
+		for i := 0; i < n; i++ {
+			if err := dict.Add(cs.keyFn(i), NewObjectFromInt64(int64(i))); err != nil {
+				t.Logf("%s: add %d expect success, but got %v", cs.name, i, err)
+				t.FailNow()
+			}
+		}
+		// 新建的等值key能查到，重复添加失败
+		for i := 0; i < n; i++ {
+			if v := dict.Get(cs.keyFn(i)); v == nil || v.ptr.(int64) != int64(i) {
+				t.Logf("%s: get %d expect %d, but got %v", cs.name, i, i, v)
+				t.FailNow()
+			}
+			if dict.Add(cs.keyFn(i), nil) == nil {
+				t.Logf("%s: add %d again expect exist", cs.name, i)
+				t.FailNow()
+			}
+		}
+		// 分布均匀，不会挤在同一个bucket
+		for dict.isRehash() {
+			dict.expandStep(1)
+		}
+		maxChain := 0
+		for _, head := range dict.ht[0].entries {
+			chain := 0
+			for cur := head; cur != nil; cur = cur.next {
+				chain++
+			}
+			if chain > maxChain {
+				maxChain = chain
+			}
+		}
+		if maxChain > 10 {
+			t.Logf("%s: expect keys spread over buckets, but max chain %d", cs.name, maxChain)
+			t.FailNow()
+		}
+		for i := 0; i < n; i += 2 {
+			if !dict.Del(cs.keyFn(i)) {
+				t.Logf("%s: del %d expect success", cs.name, i)
+				t.FailNow()
+			}
+		}
+		if dict.Len() != n/2 || dict.Get(cs.keyFn(0)) != nil || dict.Get(cs.keyFn(1)) == nil {
+			t.Logf("%s: expect %d keys left, but got %d", cs.name, n/2, dict.Len())
+			t.FailNow()
+		}
+	}
+
+	// dup 和 destructor 钩子：插入时复制，删除、覆盖、Release 时释放
+	keyDups, valDups, keyFrees, valFrees := 0, 0, 0, 0
+	dt := StrDictType
+	dt.KeyDupFn = func(key *Obj) *Obj { keyDups++; return NewObjectFromStr(key.ToStr()) }
+	dt.ValDupFn = func(val *Obj) *Obj { valDups++; return NewObjectFromStr(val.ToStr()) }
+	dt.KeyDestructorFn = func(key *Obj) { keyFrees++; key.decrRefCount() }
+	dt.ValDestructorFn = func(val *Obj) { valFrees++; val.decrRefCount() }
+	dict := NewDict(dt)
+	key, val := NewObjectFromStr("k"), NewObjectFromStr("v")
+	_ = dict.Add(key, val)
+	if dict.Len() != 1 || key.refCount != 1 || val.refCount != 1 {
+		t.Logf("expect dict holds copies, but key ref %d val ref %d", key.refCount, val.refCount)
+		t.FailNow()
+	}
+	_ = dict.Set(key, NewObjectFromStr("v2"))
+	_ = dict.Add(NewObjectFromStr("k2"), nil)
+	dict.Del(NewObjectFromStr("k2"))
+	_ = dict.Add(NewObjectFromStr("k3"), val)
+	dict.Release()
+	if keyDups != 3 || valDups != 3 || keyFrees != 3 || valFrees != 3 {
+		t.Logf("expect 3 dups and frees, but got key %d/%d val %d/%d", keyDups, keyFrees, valDups, valFrees)
+		t.FailNow()
+	}
+	if dict.Get(key) != nil || val.ToStr() != "v" {
+		t.Logf("expect dict released and original value untouched")
+		t.FailNow()
+	}
+}
+
+// 比较 SipHash 和原来的 CRC32 的速度，以及 key 落到 bucket 的分布
+// max-chain 为最长链表，chi2 为与均匀分布的卡方值 (期望约等于 bucket 数)
+func Benchmark_Hash(b *testing.B) {
+	hashes := []struct {
+		name string
+		fn   func([]byte) uint64
+	}{
+		{"siphash", Hash},
+		{"crc32", func(key []byte) uint64 { return uint64(crc32.ChecksumIEEE(key)) }},
+	}
+	const buckets = 1 << 16
+	keys := make([][]byte, buckets)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("user:%d", i))
+	}
+	for _, h := range hashes {
+		b.Run(h.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				h.fn(keys[i&(buckets-1)])
+			}
+			b.StopTimer()
+			counts := make([]int, buckets)
+			maxChain := 0
+			for _, key := range keys {
+				idx := h.fn(key) & (buckets - 1)
+				if counts[idx]++; counts[idx] > maxChain {
+					maxChain = counts[idx]
+				}
+			}
+			chi2 := 0.0
+			for _, cnt := range counts {
+				chi2 += float64((cnt - 1) * (cnt - 1))
+			}
+			b.ReportMetric(float64(maxChain), "max-chain")
+			b.ReportMetric(chi2, "chi2")
+		})
+	}
+}
+
+// 构造不依赖网络的client，直接执行命令并返回回复内容
+func newTestClient() *Client {
+	server.dbs = make([]*DB, 16)
+	for i := range server.dbs {
+		server.dbs[i] = newDB(i)
+	}
+	server.readyKeys = nil
+	if server.eventLoop == nil {
+		server.eventLoop, _ = ae.CreateEventLoop()
+	}
+	return newTestPeer(server.dbs[0])
+}
+
+// 和其他测试client共享同一个db，fd 使用 socketpair 以便注册读写事件
+func newTestPeer(db *DB) *Client {
+	fds, _ := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	return &Client{
+		fd:    fds[0],
+		db:    db,
+		args:  make([]*Obj, 0),
+		reply: NewList(ListType{EqualFn: ListEqualFn}),
+	}
+}
+
+func execCmd(c *Client, args ...string) string {
+	for _, arg := range args {
+		c.args = append(c.args, NewObjectFromStr(arg))
+	}
+	_ = processCommand(c)
+	return readReply(c)
+}
+
+// 取出并清空client当前的回复
+func readReply(c *Client) string {
+	var sb strings.Builder
+	for cur := c.reply.Head; cur != nil; cur = cur.Next {
+		sb.WriteString(cur.Val.ToStr())
+	}
+	c.reply = NewList(ListType{EqualFn: ListEqualFn})
+	return sb.String()
+}
+
+func Test_ObjectEncoding(t *testing.T) {
+	c := newTestClient()
+	cases := []struct {
+		val      string
+		encoding string
+	}{
+		{"123", "int"},
+		{"-12345678901", "int"},
+		{"0123", "embstr"},
+		{"hello", "embstr"},
+		{strings.Repeat("a", 45), "raw"},
+	}
+	for _, cs := range cases {
+		execCmd(c, "SET", "k", cs.val)
+		if got := execCmd(c, "OBJECT", "ENCODING", "k"); got != "$"+strconv.Itoa(len(cs.encoding))+"\r\n"+cs.encoding+"\r\n" {
+			t.Logf("val %v expect encoding %v, but got %q", cs.val, cs.encoding, got)
+			t.FailNow()
+		}
+		if got := execCmd(c, "GET", "k"); got != "+"+cs.val+"\r\n" {
+			t.Logf("get expect %v, but got %q", cs.val, got)
+			t.FailNow()
+		}
+	}
+
+	// 小整数使用共享对象，覆盖写不能修改共享对象本身
+	execCmd(c, "SET", "a", "7")
+	execCmd(c, "SET", "b", "7")
+	if c.db.dict.Get(NewObjectFromStr("a")) != sharedInts[7] {
+		t.Logf("expect shared integer object")
+		t.FailNow()
+	}
+	execCmd(c, "SET", "a", "8")
+	if v := c.db.dict.Get(NewObjectFromStr("b")); v.ToStr() != "7" || sharedInts[7].ToStr() != "7" {
+		t.Logf("shared integer object modified, b is %v", v.ToStr())
+		t.FailNow()
+	}
+}
+
+func Test_Deque(t *testing.T) {
+	dq := NewDeque()
+	n := 100
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			dq.PushBack(NewObjectFromInt64(int64(i)))
+		} else {
+			dq.PushFront(NewObjectFromInt64(int64(i)))
+		}
+	}
+	// 99 97 ... 1 0 2 ... 98
+	if dq.Len() != n || dq.Index(0).ToStr() != "99" || dq.Index(n-1).ToStr() != "98" {
+		t.Logf("len %v head %v tail %v", dq.Len(), dq.Index(0).ToStr(), dq.Index(n-1).ToStr())
+		t.FailNow()
+	}
+	dq.Insert(50, NewObjectFromStr("mid"))
+	dq.Insert(1, NewObjectFromStr("front"))
+	if dq.Index(51).ToStr() != "mid" || dq.Index(1).ToStr() != "front" || dq.Index(52).ToStr() != "0" {
+		t.Logf("insert failed, idx51 %v idx1 %v", dq.Index(51).ToStr(), dq.Index(1).ToStr())
+		t.FailNow()
+	}
+	dq.Remove(51)
+	dq.Remove(1)
+	for i := 0; i < n-1; i++ {
+		dq.PopFront()
+	}
+	if dq.Len() != 1 || dq.PopBack().ToStr() != "98" || len(dq.buf) != dequeMinCap {
+		t.Logf("pop failed, len %v cap %v", dq.Len(), len(dq.buf))
+		t.FailNow()
+	}
+}
+
+func Test_ListCmd(t *testing.T) {
+	c := newTestClient()
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"RPUSH", "l", "a", "b", "c"}, ":3\r\n"},
+		{[]string{"LPUSH", "l", "x"}, ":4\r\n"},
+		{[]string{"LRANGE", "l", "0", "-1"}, "*4\r\n$1\r\nx\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{[]string{"LINDEX", "l", "-1"}, "$1\r\nc\r\n"},
+		{[]string{"LINSERT", "l", "AFTER", "a", "b"}, ":5\r\n"},
+		{[]string{"LREM", "l", "-1", "b"}, ":1\r\n"},
+		{[]string{"LRANGE", "l", "1", "3"}, "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{[]string{"LSET", "l", "0", "y"}, "+OK\r\n"},
+		{[]string{"LSET", "l", "9", "y"}, "-ERR index out of range\r\n"},
+		{[]string{"LMOVE", "l", "l2", "LEFT", "RIGHT"}, "$1\r\ny\r\n"},
+		{[]string{"LTRIM", "l", "1", "-1"}, "+OK\r\n"},
+		{[]string{"LLEN", "l"}, ":2\r\n"},
+		{[]string{"RPOP", "l", "5"}, "*2\r\n$1\r\nc\r\n$1\r\nb\r\n"},
+		{[]string{"LLEN", "l"}, ":0\r\n"},
+		{[]string{"LPOP", "l"}, "$-1\r\n"},
+		{[]string{"LPOP", "l2"}, "$1\r\ny\r\n"},
+		{[]string{"SET", "s", "v"}, "+OK\r\n"},
+		{[]string{"LPUSH", "s", "v"}, "-" + respWrongType + "\r\n"},
+	}
+	for _, cs := range cases {
+		if got := execCmd(c, cs.args...); got != cs.want {
+			t.Logf("%v expect %q, but got %q", cs.args, cs.want, got)
+			t.FailNow()
+		}
+	}
+}
+
+func Test_BlockingListCmd(t *testing.T) {
+	a := newTestClient()
+	b := newTestPeer(a.db)
+	c := newTestPeer(a.db)
+
+	// 阻塞在多个key上，push 后在 beforeSleep 中被唤醒
+	if got := execCmd(a, "BLPOP", "q1", "q2", "0"); got != "" || a.flags&clientFlag_Blocked == 0 {
+		t.Logf("expect blocked, but got %q", got)
+		t.FailNow()
+	}
+	execCmd(b, "RPUSH", "q2", "x", "y")
+	handleClientsBlockedOnKeys()
+	if got := readReply(a); got != "*2\r\n$2\r\nq2\r\n$1\r\nx\r\n" || a.flags&clientFlag_Blocked != 0 {
+		t.Logf("expect served, but got %q", got)
+		t.FailNow()
+	}
+	if len(a.db.blockingKeys) != 0 {
+		t.Logf("expect blockingKeys empty, but %v", a.db.blockingKeys)
+		t.FailNow()
+	}
+
+	// 先阻塞的client先被服务
+	execCmd(a, "BLMPOP", "0", "1", "q3", "RIGHT", "COUNT", "2")
+	execCmd(c, "BLMOVE", "q3", "dst", "LEFT", "LEFT", "0")
+	execCmd(b, "RPUSH", "q3", "1", "2", "3")
+	handleClientsBlockedOnKeys()
+	if got := readReply(a); got != "*2\r\n$2\r\nq3\r\n*2\r\n$1\r\n3\r\n$1\r\n2\r\n" {
+		t.Logf("expect a served first, but got %q", got)
+		t.FailNow()
+	}
+	if got := readReply(c); got != "$1\r\n1\r\n" {
+		t.Logf("expect c served, but got %q", got)
+		t.FailNow()
+	}
+	if got := execCmd(b, "LRANGE", "dst", "0", "-1"); got != "*1\r\n$1\r\n1\r\n" {
+		t.Logf("expect dst [1], but got %q", got)
+		t.FailNow()
+	}
+
+	// 目标类型不对时回复错误并解除阻塞，源list不变
+	execCmd(b, "SET", "str", "v")
+	execCmd(c, "BLMOVE", "q5", "str", "LEFT", "LEFT", "0")
+	execCmd(b, "RPUSH", "q5", "x")
+	handleClientsBlockedOnKeys()
+	if got := readReply(c); got != "-"+respWrongType+"\r\n" || c.flags&clientFlag_Blocked != 0 {
+		t.Logf("expect wrongtype and unblocked, but got %q", got)
+		t.FailNow()
+	}
+	if got := execCmd(b, "LRANGE", "q5", "0", "-1"); got != "*1\r\n$1\r\nx\r\n" || len(b.db.blockingKeys) != 0 {
+		t.Logf("expect q5 [x] and no blocked clients, but got %q %v", got, b.db.blockingKeys)
+		t.FailNow()
+	}
+
+	// 超时
+	events := server.eventLoop.Range()
+	execCmd(a, "BRPOP", "q4", "0.01")
+	if a.bstate.timeoutId == 0 || server.eventLoop.Range() != events+1 {
+		t.Logf("expect timeout event")
+		t.FailNow()
+	}
+	blockedTimeoutHandler(a)
+	if got := readReply(a); got != "*-1\r\n" || server.eventLoop.Range() != events {
+		t.Logf("expect timeout null reply, but got %q", got)
+		t.FailNow()
+	}
+	if got := execCmd(a, "BLPOP", "q4", "-1"); got != "-ERR timeout is negative\r\n" {
+		t.Logf("expect negative timeout err, but got %q", got)
+		t.FailNow()
+	}
+}
+
+func Test_BlockingZsetCmd(t *testing.T) {
+	a := newTestClient()
+	b := newTestPeer(a.db)
+	c := newTestPeer(a.db)
+
+	if got := execCmd(a, "BZPOPMIN", "z1", "z2", "0"); got != "" || a.flags&clientFlag_Blocked == 0 {
+		t.Logf("expect blocked, but got %q", got)
+		t.FailNow()
+	}
+	execCmd(c, "BZMPOP", "0", "1", "z2", "MAX", "COUNT", "2")
+	execCmd(b, "ZADD", "z2", "1", "a", "2", "b", "3", "c", "4", "d")
+	handleClientsBlockedOnKeys()
+	if got := readReply(a); got != "*3\r\n$2\r\nz2\r\n$1\r\na\r\n$1\r\n1\r\n" {
+		t.Logf("expect a served first, but got %q", got)
+		t.FailNow()
+	}
+	if got := readReply(c); got != "*2\r\n$2\r\nz2\r\n*2\r\n*2\r\n$1\r\nd\r\n$1\r\n4\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n" {
+		t.Logf("expect c served, but got %q", got)
+		t.FailNow()
+	}
+	if len(a.db.blockingKeys) != 0 {
+		t.Logf("expect blockingKeys empty, but %v", a.db.blockingKeys)
+		t.FailNow()
+	}
+	if got := execCmd(a, "BZPOPMAX", "z2", "0"); got != "*3\r\n$2\r\nz2\r\n$1\r\nb\r\n$1\r\n2\r\n" {
+		t.Logf("expect pop without blocking, but got %q", got)
+		t.FailNow()
+	}
+
+	// list 类型的key不会唤醒 zset 阻塞
+	execCmd(a, "BZPOPMIN", "k", "0.01")
+	execCmd(b, "RPUSH", "k", "x")
+	handleClientsBlockedOnKeys()
+	if a.flags&clientFlag_Blocked == 0 {
+		t.Logf("expect still blocked")
+		t.FailNow()
+	}
+	blockedTimeoutHandler(a)
+	if got := readReply(a); got != "*-1\r\n" {
+		t.Logf("expect timeout null reply, but got %q", got)
+		t.FailNow()
+	}
+	if got := execCmd(a, "BZPOPMIN", "k", "0"); got != "-"+respWrongType+"\r\n" {
+		t.Logf("expect wrongtype, but got %q", got)
+		t.FailNow()
+	}
+	if got := execCmd(a, "BZMPOP", "0", "1", "z", "LEFT"); got != "-ERR syntax error\r\n" {
+		t.Logf("expect syntax err, but got %q", got)
+		t.FailNow()
+	}
+}
+
+func Test_HashCmd(t *testing.T) {
+	c := newTestClient()
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"HSET", "h", "name", "tom", "age", "18"}, ":2\r\n"},
+		{[]string{"HSET", "h", "age", "19"}, ":0\r\n"},
+		{[]string{"HSETNX", "h", "age", "20"}, ":0\r\n"},
+		{[]string{"HMGET", "h", "name", "none"}, "*2\r\n$3\r\ntom\r\n$-1\r\n"},
+		{[]string{"HINCRBY", "h", "age", "2"}, ":21\r\n"},
+		{[]string{"HINCRBY", "h", "name", "2"}, "-ERR hash value is not an integer\r\n"},
+		{[]string{"HINCRBYFLOAT", "h", "score", "10.5"}, "$4\r\n10.5\r\n"},
+		{[]string{"HINCRBYFLOAT", "h", "score", "0.1"}, "$4\r\n10.6\r\n"},
+		{[]string{"HSTRLEN", "h", "name"}, ":3\r\n"},
+		{[]string{"HEXISTS", "h", "score"}, ":1\r\n"},
+		{[]string{"HDEL", "h", "score", "none"}, ":1\r\n"},
+		{[]string{"HGETALL", "h"}, "*4\r\n$4\r\nname\r\n$3\r\ntom\r\n$3\r\nage\r\n$2\r\n21\r\n"},
+		{[]string{"OBJECT", "ENCODING", "h"}, "$8\r\nlistpack\r\n"},
+		{[]string{"HRANDFIELD", "h", "-3"}, ""},
+		{[]string{"HRANDFIELD", "h", "-9223372036854775808"}, "-ERR value is out of range\r\n"},
+		{[]string{"HRANDFIELD", "h", "4611686018427387904", "WITHVALUES"}, "-ERR value is out of range\r\n"},
+		{[]string{"HSCAN", "h", "0", "COUNT", "10"}, "*2\r\n$1\r\n0\r\n*4\r\n$4\r\nname\r\n$3\r\ntom\r\n$3\r\nage\r\n$2\r\n21\r\n"},
+		{[]string{"HDEL", "h", "name", "age"}, ":2\r\n"},
+		{[]string{"HLEN", "h"}, ":0\r\n"},
+		{[]string{"HSET", "h", "f"}, "-ERR wrong number of arguments for 'hset' command\r\n"},
+		{[]string{"HSET", "h", "f", "v", "g"}, "-ERR wrong number of arguments for 'hset' command\r\n"},
+	}
+	for _, cs := range cases {
+		got := execCmd(c, cs.args...)
+		if cs.want != "" && got != cs.want {
+			t.Logf("%v expect %q, but got %q", cs.args, cs.want, got)
+			t.FailNow()
+		}
+	}
+
+	// 超过阈值转为 hashtable 编码
+	for i := 0; i <= hashMaxListpackEntries; i++ {
+		execCmd(c, "HSET", "big", "f"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+	if got := execCmd(c, "OBJECT", "ENCODING", "big"); got != "$9\r\nhashtable\r\n" {
+		t.Logf("expect hashtable encoding, but got %q", got)
+		t.FailNow()
+	}
+	if got := execCmd(c, "HGET", "big", "f100"); got != "$3\r\n100\r\n" {
+		t.Logf("expect 100, but got %q", got)
+		t.FailNow()
+	}
+	if got := execCmd(c, "HLEN", "big"); got != ":129\r\n" {
+		t.Logf("expect 129, but got %q", got)
+		t.FailNow()
+	}
+
+	// HRANDFIELD：负数可重复，正数远小于 field 数时抽样去重，接近时全部复制后挑选
+	if got := execCmd(c, "HRANDFIELD", "big"); !strings.HasPrefix(got, "$") || execCmd(c, "HEXISTS", "big", strings.Split(got, "\r\n")[1]) != ":1\r\n" {
+		t.Logf("expect a field of big, but got %q", got)
+		t.FailNow()
+	}
+	if got := execCmd(c, "HRANDFIELD", "big", "-200", "WITHVALUES"); !strings.HasPrefix(got, "*400\r\n") {
+		t.Logf("expect 200 pairs, but got %q", got)
+		t.FailNow()
+	}
+	for _, count := range []int{1, 5, 40, 100, 129, 200} {
+		lines := strings.Split(execCmd(c, "HRANDFIELD", "big", strconv.Itoa(count), "WITHVALUES"), "\r\n")
+		want := count
+		if want > hashMaxListpackEntries+1 {
+			want = hashMaxListpackEntries + 1
+		}
+		if lines[0] != "*"+strconv.Itoa(want*2) {
+			t.Logf("HRANDFIELD %d expect %d pairs, but got %q", count, want, lines[0])
+			t.FailNow()
+		}
+		seen := make(map[string]bool)
+		for i := 2; i+2 < len(lines); i += 4 {
+			field, val := lines[i], lines[i+2]
+			if seen[field] || "f"+val != field {
+				t.Logf("HRANDFIELD %d expect distinct field with its value, but got %q %q", count, field, val)
+				t.FailNow()
+			}
+			seen[field] = true
+		}
+	}
+	execCmd(c, "HSET", "long", "f", strings.Repeat("v", hashMaxListpackValue+1))
+	if got := execCmd(c, "OBJECT", "ENCODING", "long"); got != "$9\r\nhashtable\r\n" {
+		t.Logf("expect hashtable encoding, but got %q", got)
+		t.FailNow()
+	}
+}
+
+func Test_Intset(t *testing.T) {
+	is := NewIntset()
+	for _, v := range []int64{5, -3, 100, 5, 0} {
+		is.Add(v)
+	}
+	if is.Len() != 4 || is.Get(0) != -3 || is.Get(3) != 100 {
+		t.Logf("intset contents %v", is.contents)
+		t.FailNow()
+	}
+	if !is.Remove(5) || is.Remove(5) || is.Find(5) || !is.Find(0) {
+		t.Logf("intset remove failed, contents %v", is.contents)
+		t.FailNow()
+	}
+}
+
+func Test_SetCmd(t *testing.T) {
+	c := newTestClient()
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"SADD", "s1", "3", "1", "2", "1"}, ":3\r\n"},
+		{[]string{"OBJECT", "ENCODING", "s1"}, "$6\r\nintset\r\n"},
+		{[]string{"SMEMBERS", "s1"}, "*3\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n"},
+		{[]string{"SADD", "s2", "2", "3", "a"}, ":3\r\n"},
+		{[]string{"OBJECT", "ENCODING", "s2"}, "$9\r\nhashtable\r\n"},
+		{[]string{"SMISMEMBER", "s2", "a", "1"}, "*2\r\n:1\r\n:0\r\n"},
+		{[]string{"SINTERCARD", "2", "s1", "s2"}, ":2\r\n"},
+		{[]string{"SINTERCARD", "2", "s1", "s2", "LIMIT", "1"}, ":1\r\n"},
+		{[]string{"SDIFF", "s1", "s2"}, "*1\r\n$1\r\n1\r\n"},
+		{[]string{"SINTERSTORE", "dst", "s1", "s2"}, ":2\r\n"},
+		{[]string{"SMEMBERS", "dst"}, "*2\r\n$1\r\n2\r\n$1\r\n3\r\n"},
+		{[]string{"SUNIONSTORE", "dst", "s1", "s2", "none"}, ":4\r\n"},
+		{[]string{"SDIFFSTORE", "dst", "s1", "s1"}, ":0\r\n"},
+		{[]string{"SCARD", "dst"}, ":0\r\n"},
+		{[]string{"SMOVE", "s2", "s1", "a"}, ":1\r\n"},
+		{[]string{"OBJECT", "ENCODING", "s1"}, "$9\r\nhashtable\r\n"},
+		{[]string{"SISMEMBER", "s1", "a"}, ":1\r\n"},
+		{[]string{"SREM", "s1", "1", "2", "x"}, ":2\r\n"},
+		{[]string{"SPOP", "s1", "10"}, ""},
+		{[]string{"SCARD", "s1"}, ":0\r\n"},
+		{[]string{"SRANDMEMBER", "s2", "-5"}, ""},
+		{[]string{"SRANDMEMBER", "s2", "-9223372036854775808"}, "-ERR value is out of range\r\n"},
+		{[]string{"SRANDMEMBER", "s2", "4611686018427387904"}, "-ERR value is out of range\r\n"},
+		{[]string{"SRANDMEMBER", "none", "-9223372036854775807"}, "-ERR value is out of range\r\n"},
+		{[]string{"SSCAN", "s2", "0"}, ""},
+	}
+	for _, cs := range cases {
+		got := execCmd(c, cs.args...)
+		if cs.want != "" && got != cs.want {
+			t.Logf("%v expect %q, but got %q", cs.args, cs.want, got)
+			t.FailNow()
+		}
+	}
+	if got := execCmd(c, "SRANDMEMBER", "s2", "5"); got != "*2\r\n$1\r\n2\r\n$1\r\n3\r\n" && got != "*2\r\n$1\r\n3\r\n$1\r\n2\r\n" {
+		t.Logf("expect distinct members, but got %q", got)
+		t.FailNow()
+	}
+
+	// 超过 intset 阈值转为 hashtable
+	for i := 0; i <= setMaxIntsetEntries; i++ {
+		execCmd(c, "SADD", "big", strconv.Itoa(i))
+	}
+	if got := execCmd(c, "OBJECT", "ENCODING", "big"); got != "$9\r\nhashtable\r\n" {
+		t.Logf("expect hashtable encoding, but got %q", got)
+		t.FailNow()
+	}
+	if got := execCmd(c, "SISMEMBER", "big", "512"); got != ":1\r\n" {
+		t.Logf("expect member, but got %q", got)
+		t.FailNow()
+	}
+
+	// SRANDMEMBER 正数 count：远小于集合时抽样去重，接近集合大小时全部复制后挑选
+	for _, count := range []int{1, 5, 100, 400, 513, 600} {
+		lines := strings.Split(execCmd(c, "SRANDMEMBER", "big", strconv.Itoa(count)), "\r\n")
+		want := count
+		if want > setMaxIntsetEntries+1 {
+			want = setMaxIntsetEntries + 1
+		}
+		if lines[0] != "*"+strconv.Itoa(want) {
+			t.Logf("SRANDMEMBER %d expect %d members, but got %q", count, want, lines[0])
+			t.FailNow()
+		}
+		seen := make(map[string]bool)
+		for i := 2; i < len(lines); i += 2 {
+			if seen[lines[i]] || execCmd(c, "SISMEMBER", "big", lines[i]) != ":1\r\n" {
+				t.Logf("SRANDMEMBER %d expect distinct members, but got %q", count, lines[i])
+				t.FailNow()
+			}
+			seen[lines[i]] = true
+		}
+	}
+}
+
+func Test_Zskiplist(t *testing.T) {
+	zsl := newZskiplist()
+	n := 1000
+	for i := 0; i < n; i++ {
+		// score 有重复，按 member 排序
+		zsl.Insert(float64(i/2), NewObjectFromStr(fmt.Sprintf("m%04d", i)))
+	}
+	for i := 0; i < n; i += 3 {
+		if !zsl.Delete(float64(i/2), NewObjectFromStr(fmt.Sprintf("m%04d", i))) {
+			t.Logf("delete m%04d failed", i)
+			t.FailNow()
+		}
+	}
+	rank := 0
+	for i := 0; i < n; i++ {
+		if i%3 == 0 {
+			continue
+		}
+		rank++
+		member := NewObjectFromStr(fmt.Sprintf("m%04d", i))
+		if got := zsl.GetRank(float64(i/2), member); got != rank {
+			t.Logf("m%04d expect rank %v, but got %v", i, rank, got)
+			t.FailNow()
+		}
+		if x := zsl.GetElementByRank(rank); x == nil || !Equal(x.member, member) {
+			t.Logf("rank %v expect m%04d", rank, i)
+			t.FailNow()
+		}
+	}
+	if zsl.length != rank || zsl.tail.member.ToStr() != "m0998" {
+		t.Logf("length %v expect %v", zsl.length, rank)
+		t.FailNow()
+	}
+}
+
+func Test_ZsetCmd(t *testing.T) {
+	c := newTestClient()
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"ZADD", "z", "1", "a", "2", "b", "3", "c"}, ":3\r\n"},
+		{[]string{"ZADD", "z", "NX", "XX", "1", "a"}, "-ERR XX and NX options at the same time are not compatible\r\n"},
+		{[]string{"ZADD", "z", "GT", "CH", "0", "a", "5", "b", "4", "d"}, ":2\r\n"},
+		{[]string{"ZADD", "z", "INCR", "1.5", "a"}, "$3\r\n2.5\r\n"},
+		{[]string{"ZADD", "z", "XX", "INCR", "1", "none"}, "$-1\r\n"},
+		{[]string{"ZINCRBY", "z", "-1", "c"}, "$1\r\n2\r\n"},
+		// c:2 a:2.5 d:4 b:5
+		{[]string{"ZRANGE", "z", "0", "-1", "WITHSCORES"}, "*8\r\n$1\r\nc\r\n$1\r\n2\r\n$1\r\na\r\n$3\r\n2.5\r\n$1\r\nd\r\n$1\r\n4\r\n$1\r\nb\r\n$1\r\n5\r\n"},
+		{[]string{"ZRANGE", "z", "0", "1", "REV"}, "*2\r\n$1\r\nb\r\n$1\r\nd\r\n"},
+		{[]string{"ZRANGE", "z", "(2", "+inf", "BYSCORE", "LIMIT", "1", "2"}, "*2\r\n$1\r\nd\r\n$1\r\nb\r\n"},
+		{[]string{"ZRANGE", "z", "4", "-inf", "BYSCORE", "REV", "LIMIT", "1", "1"}, "*1\r\n$1\r\na\r\n"},
+		{[]string{"ZRANGE", "z", "0", "1", "LIMIT", "0", "1"}, "-ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX\r\n"},
+		{[]string{"ZCOUNT", "z", "2", "(5"}, ":3\r\n"},
+		{[]string{"ZRANK", "z", "d"}, ":2\r\n"},
+		{[]string{"ZREVRANK", "z", "d", "WITHSCORE"}, "*2\r\n:1\r\n$1\r\n4\r\n"},
+		{[]string{"ZMSCORE", "z", "a", "none"}, "*2\r\n$3\r\n2.5\r\n$-1\r\n"},
+		{[]string{"ZPOPMIN", "z"}, "*2\r\n$1\r\nc\r\n$1\r\n2\r\n"},
+		{[]string{"ZPOPMAX", "z", "2"}, "*4\r\n$1\r\nb\r\n$1\r\n5\r\n$1\r\nd\r\n$1\r\n4\r\n"},
+		{[]string{"ZREM", "z", "a", "none"}, ":1\r\n"},
+		{[]string{"ZCARD", "z"}, ":0\r\n"},
+		{[]string{"ZADD", "lex", "0", "a", "0", "b", "0", "c", "0", "d"}, ":4\r\n"},
+		{[]string{"ZRANGE", "lex", "[b", "(d", "BYLEX"}, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{[]string{"ZRANGE", "lex", "+", "(b", "BYLEX", "REV"}, "*2\r\n$1\r\nd\r\n$1\r\nc\r\n"},
+		{[]string{"ZRANGE", "lex", "a", "b", "BYLEX"}, "-ERR min or max not valid string range item\r\n"},
+	}
+	for _, cs := range cases {
+		if got := execCmd(c, cs.args...); got != cs.want {
+			t.Logf("%v expect %q, but got %q", cs.args, cs.want, got)
+			t.FailNow()
+		}
+	}
+
+	// listpack 和 skiplist 编码结果一致
+	for i := 0; i < zsetMaxListpackEntries; i++ {
+		execCmd(c, "ZADD", "small", strconv.Itoa(i%50), "m"+strconv.Itoa(i))
+		execCmd(c, "ZADD", "big", strconv.Itoa(i%50), "m"+strconv.Itoa(i))
+	}
+	long := strings.Repeat("m", zsetMaxListpackValue+1)
+	execCmd(c, "ZADD", "big", "0", long)
+	execCmd(c, "ZREM", "big", long)
+	if got := execCmd(c, "OBJECT", "ENCODING", "big"); got != "$8\r\nskiplist\r\n" {
+		t.Logf("expect skiplist encoding, but got %q", got)
+		t.FailNow()
+	}
+	if got := execCmd(c, "OBJECT", "ENCODING", "small"); got != "$8\r\nlistpack\r\n" {
+		t.Logf("expect listpack encoding, but got %q", got)
+		t.FailNow()
+	}
+	for _, args := range [][]string{
+		{"ZRANGE", "%s", "10", "(20", "BYSCORE", "WITHSCORES", "LIMIT", "3", "5"},
+		{"ZRANGE", "%s", "30", "10", "BYSCORE", "REV", "LIMIT", "2", "4"},
+		{"ZCOUNT", "%s", "(10", "20"},
+		{"ZRANK", "%s", "m60"},
+		{"ZREVRANK", "%s", "m60"},
+		{"ZRANGE", "%s", "-5", "-3", "REV"},
+	} {
+		var got []string
+		for _, name := range []string{"small", "big"} {
+			cmdArgs := append([]string{}, args...)
+			cmdArgs[1] = name
+			got = append(got, execCmd(c, cmdArgs...))
+		}
+		if got[0] != got[1] {
+			t.Logf("%v listpack %q, skiplist %q", args, got[0], got[1])
+			t.FailNow()
+		}
+	}
+
+	// ZRANDMEMBER：负数可重复，正数远小于元素个数时抽样去重，接近时全部复制后挑选
+	for _, name := range []string{"small", "big"} {
+		if got := execCmd(c, "ZRANDMEMBER", name, "-300", "WITHSCORES"); !strings.HasPrefix(got, "*600\r\n") {
+			t.Logf("ZRANDMEMBER %s expect 300 pairs, but got %q", name, got)
+			t.FailNow()
+		}
+		for _, count := range []int{1, 5, 40, 100, 128, 200} {
+			lines := strings.Split(execCmd(c, "ZRANDMEMBER", name, strconv.Itoa(count), "WITHSCORES"), "\r\n")
+			want := count
+			if want > zsetMaxListpackEntries {
+				want = zsetMaxListpackEntries
+			}
+			if lines[0] != "*"+strconv.Itoa(want*2) {
+				t.Logf("ZRANDMEMBER %s %d expect %d pairs, but got %q", name, count, want, lines[0])
+				t.FailNow()
+			}
+			seen := make(map[string]bool)
+			for i := 2; i+2 < len(lines); i += 4 {
+				member, score := lines[i], lines[i+2]
+				id, _ := strconv.Atoi(member[1:])
+				if seen[member] || score != strconv.Itoa(id%50) {
+					t.Logf("ZRANDMEMBER %s %d expect distinct member with its score, but got %q %q", name, count, member, score)
+					t.FailNow()
+				}
+				seen[member] = true
+			}
+		}
+	}
+}
+
+func Test_ZsetAggregateCmd(t *testing.T) {
+	c := newTestClient()
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"ZADD", "z1", "1", "a", "2", "b", "3", "c"}, ":3\r\n"},
+		{[]string{"ZADD", "z2", "10", "b", "20", "c", "30", "d"}, ":3\r\n"},
+		{[]string{"SADD", "s", "c", "d"}, ":2\r\n"},
+		{[]string{"ZUNION", "2", "z1", "z2", "WITHSCORES"}, "*8\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$2\r\n12\r\n$1\r\nc\r\n$2\r\n23\r\n$1\r\nd\r\n$2\r\n30\r\n"},
+		{[]string{"ZINTER", "2", "z1", "z2", "WEIGHTS", "2", "1", "AGGREGATE", "MIN", "WITHSCORES"}, "*4\r\n$1\r\nb\r\n$1\r\n4\r\n$1\r\nc\r\n$1\r\n6\r\n"},
+		{[]string{"ZINTER", "3", "z1", "z2", "s", "AGGREGATE", "MAX"}, "*1\r\n$1\r\nc\r\n"},
+		{[]string{"ZINTER", "2", "z1", "z1", "WEIGHTS", "1", "2", "WITHSCORES"}, "*6\r\n$1\r\na\r\n$1\r\n3\r\n$1\r\nb\r\n$1\r\n6\r\n$1\r\nc\r\n$1\r\n9\r\n"},
+		{[]string{"ZDIFF", "3", "z1", "z2", "z1"}, "*0\r\n"},
+		{[]string{"ZDIFF", "2", "z1", "z2"}, "*1\r\n$1\r\na\r\n"},
+		{[]string{"ZDIFF", "2", "z1", "z2", "AGGREGATE", "SUM"}, "-ERR syntax error\r\n"},
+		{[]string{"ZUNIONSTORE", "dst", "3", "z1", "z2", "none"}, ":4\r\n"},
+		{[]string{"ZSCORE", "dst", "c"}, "$2\r\n23\r\n"},
+		{[]string{"ZINTERSTORE", "dst", "2", "z1", "none"}, ":0\r\n"},
+		{[]string{"ZCARD", "dst"}, ":0\r\n"},
+		{[]string{"ZDIFFSTORE", "dst", "1", "z2"}, ":3\r\n"},
+		{[]string{"ZUNION", "0", "z1"}, "-ERR at least 1 input key is needed for 'zunion' command\r\n"},
+		{[]string{"ZRANGESTORE", "dst", "z1", "(1", "+inf", "BYSCORE"}, ":2\r\n"},
+		{[]string{"ZRANGE", "dst", "0", "-1"}, "*2\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{[]string{"ZRANGESTORE", "dst", "z1", "0", "-1", "WITHSCORES"}, "-ERR syntax error\r\n"},
+		{[]string{"ZREMRANGEBYSCORE", "z2", "(10", "20"}, ":1\r\n"},
+		{[]string{"ZREMRANGEBYRANK", "z2", "-1", "-1"}, ":1\r\n"},
+		{[]string{"ZRANGE", "z2", "0", "-1"}, "*1\r\n$1\r\nb\r\n"},
+		{[]string{"ZADD", "lex", "0", "a", "0", "b", "0", "c", "0", "d"}, ":4\r\n"},
+		{[]string{"ZLEXCOUNT", "lex", "(a", "+"}, ":3\r\n"},
+		{[]string{"ZREMRANGEBYLEX", "lex", "-", "[b"}, ":2\r\n"},
+		{[]string{"ZREMRANGEBYLEX", "lex", "-", "+"}, ":2\r\n"},
+		{[]string{"ZCARD", "lex"}, ":0\r\n"},
+		{[]string{"ZRANDMEMBER", "z2", "-3"}, "*3\r\n$1\r\nb\r\n$1\r\nb\r\n$1\r\nb\r\n"},
+		{[]string{"ZRANDMEMBER", "z2", "5", "WITHSCORES"}, "*2\r\n$1\r\nb\r\n$2\r\n10\r\n"},
+		{[]string{"ZRANDMEMBER", "none"}, "$-1\r\n"},
+		{[]string{"ZRANDMEMBER", "z2", "-9223372036854775808"}, "-ERR value is out of range\r\n"},
+		{[]string{"ZRANDMEMBER", "z2", "4611686018427387904", "WITHSCORES"}, "-ERR value is out of range\r\n"},
+		{[]string{"ZSCAN", "z2", "0"}, "*2\r\n$1\r\n0\r\n*2\r\n$1\r\nb\r\n$2\r\n10\r\n"},
+	}
+	for _, cs := range cases {
+		if got := execCmd(c, cs.args...); got != cs.want {
+			t.Logf("%v expect %q, but got %q", cs.args, cs.want, got)
+			t.FailNow()
+		}
+	}
+
+	// 同一个hashtable编码的key重复出现，遍历时查询的是同一个dict，不能因为rehash导致异常
+	for _, n := range []int{16, 48, 200} {
+		key := "big" + strconv.Itoa(n)
+		for i := 0; i < n; i++ {
+			execCmd(c, "SADD", key, "m"+strconv.Itoa(i))
+		}
+		want := "*" + strconv.Itoa(n) + "\r\n"
+		if got := execCmd(c, "ZINTER", "2", key, key); !strings.HasPrefix(got, want) {
+			t.Logf("ZINTER %s %s expect %q, but got %q", key, key, want, got)
+			t.FailNow()
+		}
+		if got := execCmd(c, "ZDIFF", "2", key, key); got != "*0\r\n" {
+			t.Logf("ZDIFF %s %s expect empty, but got %q", key, key, got)
+			t.FailNow()
+		}
+	}
+}
+
+func Test_ExpireCmd(t *testing.T) {
+	c := newTestClient()
+	far := strconv.FormatInt(ae.GetUnixTime()/1000+1000, 10)
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"SET", "k", "v"}, "+OK\r\n"},
+		{[]string{"TTL", "k"}, ":-1\r\n"},
+		{[]string{"TTL", "none"}, ":-2\r\n"},
+		{[]string{"EXPIRE", "none", "100"}, ":0\r\n"},
+		{[]string{"EXPIRE", "k", "100", "XX"}, ":0\r\n"},
+		{[]string{"EXPIRE", "k", "100", "GT"}, ":0\r\n"},
+		{[]string{"EXPIRE", "k", "100", "NX"}, ":1\r\n"},
+		{[]string{"EXPIRE", "k", "200", "NX"}, ":0\r\n"},
+		{[]string{"TTL", "k"}, ":100\r\n"},
+		{[]string{"EXPIRE", "k", "50", "GT"}, ":0\r\n"},
+		{[]string{"EXPIRE", "k", "50", "LT"}, ":1\r\n"},
+		{[]string{"PEXPIRE", "k", "60000", "XX", "GT"}, ":1\r\n"},
+		{[]string{"TTL", "k"}, ":60\r\n"},
+		{[]string{"EXPIREAT", "k", far}, ":1\r\n"},
+		{[]string{"EXPIRETIME", "k"}, ":" + far + "\r\n"},
+		{[]string{"PEXPIRETIME", "k"}, ":" + far + "000\r\n"},
+		{[]string{"PERSIST", "k"}, ":1\r\n"},
+		{[]string{"PERSIST", "k"}, ":0\r\n"},
+		{[]string{"PTTL", "k"}, ":-1\r\n"},
+		// SET 覆盖时清除过期时间
+		{[]string{"EXPIRE", "k", "100"}, ":1\r\n"},
+		{[]string{"SET", "k", "v2"}, "+OK\r\n"},
+		{[]string{"TTL", "k"}, ":-1\r\n"},
+		// 过期时间已过去时直接删除
+		{[]string{"PEXPIREAT", "k", "1"}, ":1\r\n"},
+		{[]string{"TTL", "k"}, ":-2\r\n"},
+		{[]string{"SET", "k", "v"}, "+OK\r\n"},
+		{[]string{"EXPIRE", "k", "10", "NX", "GT"}, "-ERR NX and XX, GT or LT options at the same time are not compatible\r\n"},
+		{[]string{"EXPIRE", "k", "10", "GT", "LT"}, "-ERR GT and LT options at the same time are not compatible\r\n"},
+		{[]string{"EXPIRE", "k", "10", "FOO"}, "-ERR Unsupported option FOO\r\n"},
+		{[]string{"EXPIRE", "k", "9223372036854775807"}, "-ERR invalid expire time in 'expire' command\r\n"},
+		{[]string{"EXPIRE", "k", "abc"}, "-ERR value is not an integer or out of range\r\n"},
+	}
+	for _, cs := range cases {
+		if got := execCmd(c, cs.args...); got != cs.want {
+			t.Logf("%v expect %q, but got %q", cs.args, cs.want, got)
+			t.FailNow()
+		}
+	}
+	if obj := c.db.expires.Get(NewObjectFromStr("k")); obj != nil {
+		t.Logf("expect no expire, but got %v", obj.ptr)
+		t.FailNow()
+	}
+	execCmd(c, "PEXPIRE", "k", "100000")
+	if obj := c.db.expires.Get(NewObjectFromStr("k")); obj == nil || obj.encoding != GEncoding_Int {
+		t.Logf("expect int encoded expire")
+		t.FailNow()
+	}
+}
+
+func Test_LazyExpire(t *testing.T) {
+	c := newTestClient()
+	execCmd(c, "SET", "k", "v")
+	execCmd(c, "RPUSH", "l", "a")
+	execCmd(c, "HSET", "h", "f", "v")
+	for _, key := range []string{"k", "l", "h"} {
+		execCmd(c, "PEXPIRE", key, "10")
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	expired := server.statExpiredKeys
+	if got := execCmd(c, "GET", "k"); got != "+null\r\n" {
+		t.Logf("expect expired key, but got %q", got)
+		t.FailNow()
+	}
+	if got := execCmd(c, "LLEN", "l"); got != ":0\r\n" {
+		t.Logf("expect expired list, but got %q", got)
+		t.FailNow()
+	}
+	// 写命令在过期key上重新创建
+	if got := execCmd(c, "HSET", "h", "f2", "v2"); got != ":1\r\n" {
+		t.Logf("expect new hash, but got %q", got)
+		t.FailNow()
+	}
+	if got := execCmd(c, "TTL", "h"); got != ":-1\r\n" {
+		t.Logf("expect no ttl, but got %q", got)
+		t.FailNow()
+	}
+	if c.db.dict.Len() != 1 || c.db.expires.Len() != 0 || server.statExpiredKeys != expired+3 {
+		t.Logf("expect expired keys deleted, dict %d expires %d", c.db.dict.Len(), c.db.expires.Len())
+		t.FailNow()
+	}
+
+	hits, misses := server.statKeyspaceHits, server.statKeyspaceMisses
+	execCmd(c, "HGET", "h", "f2")
+	execCmd(c, "HGET", "none", "f")
+	if server.statKeyspaceHits != hits+1 || server.statKeyspaceMisses != misses+1 {
+		t.Logf("expect keyspace hits/misses counted")
+		t.FailNow()
+	}
+}
+
+func Test_ActiveExpireCycle(t *testing.T) {
+	c := newTestClient()
+	const n = 1000
+	for i := 0; i < n; i++ {
+		key := "k" + strconv.Itoa(i)
+		execCmd(c, "SET", key, "v")
+		// 直接写入已过去的时间，EXPIRE 命令会立即删除
+		setExpire(c.db, NewObjectFromStr(key), ae.GetUnixTime()-1)
+	}
+	execCmd(c, "SET", "live", "v")
+	execCmd(c, "EXPIRE", "live", "100")
+
+	expired := server.statExpiredKeys
+	for i := 0; i < 1000 && c.db.expires.Len() > 1; i++ {
+		activeExpireCycle(activeExpireCycle_Slow)
+	}
+	if c.db.expires.Len() != 1 || c.db.dict.Len() != 1 || server.statExpiredKeys != expired+n {
+		t.Logf("expect expired keys deleted, dict %d expires %d", c.db.dict.Len(), c.db.expires.Len())
+		t.FailNow()
+	}
+	if server.statExpiredStalePerc <= 0 {
+		t.Logf("expect expired_stale_perc > 0, but %v", server.statExpiredStalePerc)
+		t.FailNow()
+	}
+
+	// 没有积压时 fast cycle 不执行
+	server.statExpiredStalePerc, timelimitExit = 0, false
+	last := lastFastCycle
+	activeExpireCycle(activeExpireCycle_Fast)
+	if lastFastCycle != last {
+		t.Logf("expect fast cycle skipped")
+		t.FailNow()
+	}
+
+	info := execCmd(c, "INFO", "stats")
+	for _, field := range []string{"expired_keys:" + strconv.FormatInt(server.statExpiredKeys, 10), "expired_stale_perc:"} {
+		if !strings.Contains(info, field) {
+			t.Logf("expect %q in info, but got %q", field, info)
+			t.FailNow()
+		}
+	}
+	if got := execCmd(c, "INFO", "keyspace"); !strings.Contains(got, "db0:keys=1,expires=1\r\n") {
+		t.Logf("expect keyspace info, but got %q", got)
+		t.FailNow()
+	}
+}
+
+func sortedLines(s string) string {
+	lines := strings.Split(s, "\r\n")
+	sort.Strings(lines)
+	return strings.Join(lines, "\r\n")
+}
+
+func Test_KeyspaceCmd(t *testing.T) {
+	c := newTestClient()
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"SET", "s", "v"}, "+OK\r\n"},
+		{[]string{"RPUSH", "l", "a", "b"}, ":2\r\n"},
+		{[]string{"HSET", "h", "f", "v"}, ":1\r\n"},
+		{[]string{"SADD", "set", "1", "2"}, ":2\r\n"},
+		{[]string{"ZADD", "z", "1", "a"}, ":1\r\n"},
+		{[]string{"DBSIZE"}, ":5\r\n"},
+		{[]string{"TYPE", "s"}, "+string\r\n"},
+		{[]string{"TYPE", "h"}, "+hash\r\n"},
+		{[]string{"TYPE", "z"}, "+zset\r\n"},
+		{[]string{"TYPE", "none"}, "+none\r\n"},
+		{[]string{"EXISTS", "s", "s", "none"}, ":2\r\n"},
+		{[]string{"TOUCH", "l", "none"}, ":1\r\n"},
+		// RENAME 保留过期时间
+		{[]string{"EXPIRE", "s", "100"}, ":1\r\n"},
+		{[]string{"RENAME", "s", "s2"}, "+OK\r\n"},
+		{[]string{"TTL", "s2"}, ":100\r\n"},
+		{[]string{"EXISTS", "s"}, ":0\r\n"},
+		{[]string{"RENAME", "none", "x"}, "-ERR no such key\r\n"},
+		{[]string{"RENAMENX", "s2", "l"}, ":0\r\n"},
+		{[]string{"RENAMENX", "s2", "s"}, ":1\r\n"},
+		{[]string{"RENAME", "s", "l"}, "+OK\r\n"},
+		{[]string{"TYPE", "l"}, "+string\r\n"},
+		// COPY 深拷贝
+		{[]string{"COPY", "h", "h2"}, ":1\r\n"},
+		{[]string{"HSET", "h2", "f", "v2"}, ":0\r\n"},
+		{[]string{"HGET", "h", "f"}, "$1\r\nv\r\n"},
+		{[]string{"COPY", "set", "h2"}, ":0\r\n"},
+		{[]string{"COPY", "set", "h2", "REPLACE"}, ":1\r\n"},
+		{[]string{"SADD", "h2", "3"}, ":1\r\n"},
+		{[]string{"SCARD", "set"}, ":2\r\n"},
+		{[]string{"COPY", "z", "z"}, "-ERR source and destination objects are the same\r\n"},
+		{[]string{"COPY", "z", "z2", "DB", "16"}, "-ERR DB index is out of range\r\n"},
+		{[]string{"DEL", "h", "h2", "none"}, ":2\r\n"},
+		{[]string{"UNLINK", "l"}, ":1\r\n"},
+		{[]string{"TTL", "l"}, ":-2\r\n"},
+		{[]string{"DBSIZE"}, ":2\r\n"},
+	}
+	for _, cs := range cases {
+		if got := execCmd(c, cs.args...); got != cs.want {
+			t.Logf("%v expect %q, but got %q", cs.args, cs.want, got)
+			t.FailNow()
+		}
+	}
+	if c.db.expires.Len() != 0 {
+		t.Logf("expect expires empty, but %d", c.db.expires.Len())
+		t.FailNow()
+	}
+
+	// 各类型 COPY 后内容一致，且互不影响
+	for i := 0; i < 200; i++ {
+		execCmd(c, "RPUSH", "list", strconv.Itoa(i))
+		execCmd(c, "ZADD", "zbig", strconv.Itoa(i), "m"+strconv.Itoa(i))
+		execCmd(c, "SADD", "sbig", "m"+strconv.Itoa(i))
+		execCmd(c, "HSET", "hbig", "f"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+	for _, cs := range []struct {
+		key string
+		cmd []string
+	}{
+		{"list", []string{"LRANGE", "", "0", "-1"}},
+		{"z", []string{"ZRANGE", "", "0", "-1", "WITHSCORES"}},
+		{"zbig", []string{"ZRANGE", "", "0", "-1", "WITHSCORES"}},
+		{"set", []string{"SMEMBERS", ""}},
+		{"hbig", []string{"HGETALL", ""}},
+	} {
+		execCmd(c, "COPY", cs.key, "copy", "REPLACE")
+		src := append([]string{cs.cmd[0], cs.key}, cs.cmd[2:]...)
+		dst := append([]string{cs.cmd[0], "copy"}, cs.cmd[2:]...)
+		a, b := execCmd(c, src...), execCmd(c, dst...)
+		if cs.cmd[0] == "SMEMBERS" || cs.cmd[0] == "HGETALL" { // hashtable 遍历顺序不固定
+			a, b = sortedLines(a), sortedLines(b)
+		}
+		if a != b {
+			t.Logf("%s copy mismatch: %q vs %q", cs.key, a, b)
+			t.FailNow()
+		}
+		if a, b := execCmd(c, "OBJECT", "ENCODING", cs.key), execCmd(c, "OBJECT", "ENCODING", "copy"); a != b {
+			t.Logf("%s copy encoding mismatch: %q vs %q", cs.key, a, b)
+			t.FailNow()
+		}
+	}
+	execCmd(c, "COPY", "zbig", "copy", "REPLACE")
+	execCmd(c, "ZREM", "copy", "m0")
+	if got := execCmd(c, "ZCARD", "zbig"); got != ":200\r\n" {
+		t.Logf("expect src unchanged, but got %q", got)
+		t.FailNow()
+	}
+
+	// RANDOMKEY 跳过已过期的key
+	c = newTestClient()
+	if got := execCmd(c, "RANDOMKEY"); got != "$-1\r\n" {
+		t.Logf("expect null, but got %q", got)
+		t.FailNow()
+	}
+	execCmd(c, "SET", "dead", "v")
+	setExpire(c.db, NewObjectFromStr("dead"), ae.GetUnixTime()-1)
+	execCmd(c, "SET", "alive", "v")
+	if got := execCmd(c, "RANDOMKEY"); got != "$5\r\nalive\r\n" {
+		t.Logf("expect alive, but got %q", got)
+		t.FailNow()
+	}
+}
+
+func Test_StringMatch(t *testing.T) {
+	cases := []struct {
+		pattern, str string
+		nocase       bool
+		want         bool
+	}{
+		{"*", "", false, true},
+		{"*", "anything", false, true},
+		{"", "", false, true},
+		{"", "a", false, false},
+		{"h?llo", "hello", false, true},
+		{"h?llo", "hllo", false, false},
+		{"h*llo", "hllo", false, true},
+		{"h*llo", "heeeello", false, true},
+		{"h**llo*", "hello world", false, true},
+		{"h[ae]llo", "hallo", false, true},
+		{"h[ae]llo", "hillo", false, false},
+		{"h[^e]llo", "hallo", false, true},
+		{"h[^e]llo", "hello", false, false},
+		{"h[a-b]llo", "hbllo", false, true},
+		{"h[b-a]llo", "hallo", false, true},
+		{"h[^a-z]llo", "hAllo", false, true},
+		{"h[^a-z]llo", "hAllo", true, false},
+		{"h[A-Z]llo", "hello", true, true},
+		{"HELLO", "hello", true, true},
+		{"HELLO", "hello", false, false},
+		{"h\\*llo", "h*llo", false, true},
+		{"h\\*llo", "hello", false, false},
+		{"h[\\]]llo", "h]llo", false, true},
+		{"h\\?", "h?", false, true},
+		{"h[abc", "hb", false, true},
+		{"user:*:name", "user:1000:name", false, true},
+		{"user:*:name", "user:1000:age", false, false},
+		{"a*a*a*a*a*a*a*a*a*b", strings.Repeat("a", 60), false, false},
+	}
+	for _, cs := range cases {
+		if got := stringMatch(cs.pattern, cs.str, cs.nocase); got != cs.want {
+			t.Logf("stringMatch(%q, %q, %v) expect %v, but got %v", cs.pattern, cs.str, cs.nocase, cs.want, got)
+			t.FailNow()
+		}
+	}
+}
+
+func Test_KeysCmd(t *testing.T) {
+	c := newTestClient()
+	for _, key := range []string{"user:1", "user:2", "order:1"} {
+		execCmd(c, "SET", key, "v")
+	}
+	execCmd(c, "SET", "user:3", "v")
+	setExpire(c.db, NewObjectFromStr("user:3"), ae.GetUnixTime()-1)
+
+	if got := sortedLines(execCmd(c, "KEYS", "user:*")); got != sortedLines("*2\r\n$6\r\nuser:1\r\n$6\r\nuser:2\r\n") {
+		t.Logf("expect user keys, but got %q", got)
+		t.FailNow()
+	}
+	if got := execCmd(c, "KEYS", "*"); !strings.HasPrefix(got, "*3\r\n") {
+		t.Logf("expect 3 keys, but got %q", got)
+		t.FailNow()
+	}
+	if got := execCmd(c, "KEYS", "order:[0-9]"); got != "*1\r\n$7\r\norder:1\r\n" {
+		t.Logf("expect order:1, but got %q", got)
+		t.FailNow()
+	}
+	if got := execCmd(c, "KEYS", "none*"); got != "*0\r\n" {
+		t.Logf("expect empty, but got %q", got)
+		t.FailNow()
+	}
+	// 已过期的key在遍历时删除
+	setExpire(c.db, NewObjectFromStr("user:1"), ae.GetUnixTime()-1)
+	if got := execCmd(c, "KEYS", "user:*"); got != "*1\r\n$6\r\nuser:2\r\n" {
+		t.Logf("expect user:2, but got %q", got)
+		t.FailNow()
+	}
+	if c.db.dict.Len() != 2 {
+		t.Logf("expect expired key deleted, but got %d keys", c.db.dict.Len())
+		t.FailNow()
+	}
+}
+
+func Test_DictScan(t *testing.T) {
+	d := NewDict(DictType{HashFn: Hash, EqualFn: Equal})
+	const n = 100
+	for i := 0; i < n; i++ {
+		_ = d.Add(NewObjectFromStr("k"+strconv.Itoa(i)), nil)
+	}
+
+	// 遍历过程中不断插入新key，触发扩容和rehash，原有的key都要被遍历到
+	seen := make(map[string]bool)
+	cursor, added, rehashed := uint64(0), 0, false
+	for {
+		cursor = d.Scan(cursor, func(key, val *Obj) {
+			seen[key.ToStr()] = true
+		})
+		if cursor == 0 {
+			break
+		}
+		for i := 0; i < 20 && added < 500; i++ {
+			_ = d.Add(NewObjectFromStr("new"+strconv.Itoa(added)), nil)
+			added++
+		}
+		if d.isRehash() {
+			rehashed = true
+		}
+	}
+	if !rehashed {
+		t.Logf("expect rehash during scan")
+		t.FailNow()
+	}
+	for i := 0; i < n; i++ {
+		if !seen["k"+strconv.Itoa(i)] {
+			t.Logf("expect k%d scanned", i)
+			t.FailNow()
+		}
+	}
+
+	// rehash 中途开始遍历
+	d = NewDict(DictType{HashFn: Hash, EqualFn: Equal})
+	for i := 0; !d.isRehash(); i++ {
+		_ = d.Add(NewObjectFromStr("k"+strconv.Itoa(i)), nil)
+	}
+	total := d.Len()
+	seen = make(map[string]bool)
+	for cursor = d.Scan(0, func(key, val *Obj) { seen[key.ToStr()] = true }); cursor != 0; {
+		cursor = d.Scan(cursor, func(key, val *Obj) { seen[key.ToStr()] = true })
+	}
+	if len(seen) != total {
+		t.Logf("expect %d keys scanned, but %d", total, len(seen))
+		t.FailNow()
+	}
+}
+
+// scanAll 按游标遍历到结束，返回全部元素
+func scanAll(t *testing.T, c *Client, args ...string) []string {
+	var all []string
+	cursor := "0"
+	for i := 0; i < 1000; i++ {
+		cmdArgs := append([]string{args[0]}, args[1:]...)
+		for j, arg := range cmdArgs {
+			if arg == "$cursor" {
+				cmdArgs[j] = cursor
+			}
+		}
+		lines := strings.Split(execCmd(c, cmdArgs...), "\r\n")
+		// *2 $n cursor *m ($len item)...
+		cursor = lines[2]
+		for k := 5; k < len(lines); k += 2 {
+			all = append(all, lines[k])
+		}
+		if cursor == "0" {
+			return all
+		}
+	}
+	t.Logf("%v scan not finished", args)
+	t.FailNow()
+	return nil
+}
+
+func Test_ScanCmd(t *testing.T) {
+	c := newTestClient()
+	for i := 0; i < 100; i++ {
+		execCmd(c, "SET", "str:"+strconv.Itoa(i), "v")
+	}
+	for i := 0; i < 20; i++ {
+		execCmd(c, "RPUSH", "list:"+strconv.Itoa(i), "v")
+	}
+	execCmd(c, "SET", "str:dead", "v")
+	setExpire(c.db, NewObjectFromStr("str:dead"), ae.GetUnixTime()-1)
+
+	if keys := scanAll(t, c, "SCAN", "$cursor"); len(keys) != 120 {
+		t.Logf("expect 120 keys, but %d", len(keys))
+		t.FailNow()
+	}
+	if c.db.dict.Len() != 120 {
+		t.Logf("expect expired key deleted by scan")
+		t.FailNow()
+	}
+	keys := scanAll(t, c, "SCAN", "$cursor", "MATCH", "list:*", "COUNT", "5")
+	if len(keys) != 20 {
+		t.Logf("expect 20 list keys, but %d", len(keys))
+		t.FailNow()
+	}
+	if keys := scanAll(t, c, "SCAN", "$cursor", "TYPE", "list"); len(keys) != 20 {
+		t.Logf("expect 20 list keys by type, but %d", len(keys))
+		t.FailNow()
+	}
+	if got := execCmd(c, "SCAN", "0", "COUNT", "0"); got != "-ERR syntax error\r\n" {
+		t.Logf("expect syntax error, but got %q", got)
+		t.FailNow()
+	}
+	if got := execCmd(c, "SCAN", "abc"); got != "-ERR invalid cursor\r\n" {
+		t.Logf("expect invalid cursor, but got %q", got)
+		t.FailNow()
+	}
+	if got := execCmd(c, "HSCAN", "h", "0", "TYPE", "string"); got != "*2\r\n$1\r\n0\r\n*0\r\n" {
+		t.Logf("expect empty scan, but got %q", got)
+		t.FailNow()
+	}
+
+	// hashtable 编码的 hash/set/zset 分批遍历
+	for i := 0; i < 300; i++ {
+		execCmd(c, "HSET", "h", "f"+strconv.Itoa(i), strconv.Itoa(i))
+		execCmd(c, "SADD", "s", "m"+strconv.Itoa(i))
+		execCmd(c, "ZADD", "z", strconv.Itoa(i), "m"+strconv.Itoa(i))
+	}
+	for _, cs := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{"HSCAN", "h", "$cursor"}, 600},
+		{[]string{"SSCAN", "s", "$cursor"}, 300},
+		{[]string{"ZSCAN", "z", "$cursor", "COUNT", "50"}, 600},
+		{[]string{"ZSCAN", "z", "$cursor", "MATCH", "m1?"}, 20},
+	} {
+		if items := scanAll(t, c, cs.args...); len(items) != cs.want {
+			t.Logf("%v expect %d items, but %d", cs.args, cs.want, len(items))
+			t.FailNow()
+		}
+	}
+	if items := scanAll(t, c, "HSCAN", "h", "$cursor", "MATCH", "f299"); len(items) != 2 || items[0] != "f299" || items[1] != "299" {
+		t.Logf("expect [f299 299], but got %v", items)
+		t.FailNow()
+	}
+}
+
+func Test_MultiDbCmd(t *testing.T) {
+	c := newTestClient()
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"SET", "k", "v0"}, "+OK\r\n"},
+		{[]string{"SELECT", "16"}, "-ERR DB index is out of range\r\n"},
+		{[]string{"SELECT", "x"}, "-ERR invalid DB index\r\n"},
+		{[]string{"SELECT", "1"}, "+OK\r\n"},
+		{[]string{"GET", "k"}, "+null\r\n"},
+		{[]string{"SET", "k", "v1"}, "+OK\r\n"},
+		{[]string{"SELECT", "0"}, "+OK\r\n"},
+		{[]string{"GET", "k"}, "+v0\r\n"},
+		// MOVE 目标db已存在同名key时不移动
+		{[]string{"MOVE", "k", "1"}, ":0\r\n"},
+		{[]string{"MOVE", "k", "0"}, "-ERR source and destination objects are the same\r\n"},
+		{[]string{"MOVE", "k", "16"}, "-ERR DB index is out of range\r\n"},
+		{[]string{"EXPIRE", "k", "100"}, ":1\r\n"},
+		{[]string{"MOVE", "k", "2"}, ":1\r\n"},
+		{[]string{"EXISTS", "k"}, ":0\r\n"},
+		{[]string{"MOVE", "none", "2"}, ":0\r\n"},
+		// COPY 到其他db
+		{[]string{"SET", "c", "x"}, "+OK\r\n"},
+		{[]string{"COPY", "c", "c", "DB", "3"}, ":1\r\n"},
+		{[]string{"COPY", "c", "c", "DB", "3"}, ":0\r\n"},
+		// SWAPDB 后当前连接看到的是另一个db的数据
+		{[]string{"SWAPDB", "0", "2"}, "+OK\r\n"},
+		{[]string{"TTL", "k"}, ":100\r\n"},
+		{[]string{"EXISTS", "c"}, ":0\r\n"},
+		{[]string{"SWAPDB", "x", "2"}, "-ERR invalid first DB index\r\n"},
+		{[]string{"SWAPDB", "0", "x"}, "-ERR invalid second DB index\r\n"},
+		{[]string{"SWAPDB", "0", "16"}, "-ERR DB index is out of range\r\n"},
+		{[]string{"FLUSHDB", "NOW"}, "-ERR syntax error\r\n"},
+		{[]string{"FLUSHDB"}, "+OK\r\n"},
+		{[]string{"DBSIZE"}, ":0\r\n"},
+		{[]string{"SELECT", "1"}, "+OK\r\n"},
+		{[]string{"DBSIZE"}, ":1\r\n"},
+		{[]string{"FLUSHALL", "ASYNC"}, "+OK\r\n"},
+		{[]string{"DBSIZE"}, ":0\r\n"},
+		{[]string{"SELECT", "3"}, "+OK\r\n"},
+		{[]string{"DBSIZE"}, ":0\r\n"},
+	}
+	for _, cs := range cases {
+		if got := execCmd(c, cs.args...); got != cs.want {
+			t.Logf("%v expect %q, but got %q", cs.args, cs.want, got)
+			t.FailNow()
+		}
+	}
+
+	// SWAPDB 后阻塞在 db0 上的client被唤醒
+	a := newTestClient()
+	b := newTestPeer(server.dbs[1])
+	execCmd(a, "BLPOP", "q", "0")
+	execCmd(b, "RPUSH", "q", "x")
+	handleClientsBlockedOnKeys()
+	if a.flags&clientFlag_Blocked == 0 {
+		t.Logf("expect still blocked")
+		t.FailNow()
+	}
+	execCmd(b, "SWAPDB", "0", "1")
+	handleClientsBlockedOnKeys()
+	if got := readReply(a); got != "*2\r\n$1\r\nq\r\n$1\r\nx\r\n" || a.flags&clientFlag_Blocked != 0 {
+		t.Logf("expect served after SWAPDB, but got %q", got)
+		t.FailNow()
+	}
+}
+
+func Test_Eviction(t *testing.T) {
+	c := newTestClient()
+	defer func() {
+		server.maxmemory, server.maxmemoryPolicy, server.maxmemorySamples = 0, maxmemory_NoEviction, 0
+		evictionPool = nil
+	}()
+	server.maxmemorySamples, server.statEvictedKeys = 64, 0
+	server.lfuLogFactor, server.lfuDecayTime = 10, 1
+
+	// LRU 和 LFU 策略下不使用共享整数，每个key单独记录访问信息
+	server.maxmemory, server.maxmemoryPolicy = 1<<40, maxmemory_AllKeysLRU
+	server.lruclock = 1000
+	execCmd(c, "SET", "n", "7")
+	if v := c.db.dict.Get(NewObjectFromStr("n")); v == sharedInts[7] || v.ToStr() != "7" {
+		t.Logf("expect not shared integer")
+		t.FailNow()
+	}
+
+	// allkeys-lru 淘汰空闲时间最长的key
+	for _, k := range []string{"a", "b", "c"} {
+		execCmd(c, "SET", k, "v")
+	}
+	c.db.dict.Get(NewObjectFromStr("b")).lru = 900
+	execCmd(c, "GET", "a")
+	// 单次抽样不保证覆盖全部key，淘汰池跨多次抽样保留候选
+	for i := 0; i < 100; i++ {
+		evictionPoolPopulate(c.db, c.db.dict)
+	}
+	if db, key := evictSelectKey(); db != c.db || key.ToStr() != "b" {
+		t.Logf("expect evict b, but got %v", key)
+		t.FailNow()
+	}
+
+	// LFU 计数器：对数递增，按分钟衰减
+	counter := uint32(lfuInitVal)
+	for i := 0; i < 1000; i++ {
+		counter = lfuLogIncr(counter)
+	}
+	if counter <= lfuInitVal || counter >= 255 {
+		t.Logf("expect logarithmic counter, but got %d", counter)
+		t.FailNow()
+	}
+	obj := NewObjectFromStr("x")
+	obj.lru = (lfuGetTimeInMinutes()-3)<<8 | 10
+	if got := lfuDecrAndReturn(obj); got != 7 {
+		t.Logf("expect counter decay to 7, but got %d", got)
+		t.FailNow()
+	}
+
+	// volatile-ttl 先淘汰最早过期的key
+	evictionPool = nil
+	server.maxmemoryPolicy = maxmemory_VolatileTTL
+	execCmd(c, "EXPIRE", "a", "100")
+	execCmd(c, "EXPIRE", "c", "10")
+	for i := 0; i < 100; i++ {
+		evictionPoolPopulate(c.db, c.db.expires)
+	}
+	if _, key := evictSelectKey(); key.ToStr() != "c" {
+		t.Logf("expect evict c, but got %v", key)
+		t.FailNow()
+	}
+
+	// 内存上限很小时 volatile 策略只淘汰设置了过期时间的key，淘汰完后拒绝写命令
+	evictionPool = nil
+	server.maxmemory, server.maxmemoryPolicy = 1, maxmemory_VolatileRandom
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"DBSIZE"}, ":2\r\n"},
+		{[]string{"EXISTS", "a", "c"}, ":0\r\n"},
+		{[]string{"SET", "d", "v"}, "-OOM command not allowed when used memory > 'maxmemory'.\r\n"},
+		{[]string{"DEL", "b"}, ":1\r\n"},
+		{[]string{"GET", "n"}, "+7\r\n"},
+	}
+	for _, cs := range cases {
+		if got := execCmd(c, cs.args...); got != cs.want {
+			t.Logf("%v expect %q, but got %q", cs.args, cs.want, got)
+			t.FailNow()
+		}
+	}
+	server.maxmemoryPolicy = maxmemory_AllKeysRandom
+	if got := execCmd(c, "DBSIZE"); got != ":0\r\n" {
+		t.Logf("expect all keys evicted, but got %q", got)
+		t.FailNow()
+	}
+	if !strings.Contains(genInfoString("stats"), "evicted_keys:3\r\n") {
+		t.Logf("expect evicted_keys:3, but got %q", genInfoString("stats"))
+		t.FailNow()
+	}
+}
+
+// 每个db的 usedMemory 应等于全部key和value重新估算的大小
+// skipReply 跳过一个完整的RESP回复，返回剩余部分，数组按声明的长度递归跳过
+func skipReply(s string) (string, bool) {
+	i := strings.Index(s, "\r\n")
+	if i < 1 {
+		return s, false
+	}
+	line, rest := s[1:i], s[i+2:]
+	switch s[0] {
+	case '+', '-', ':':
+		return rest, true
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < -1 {
+			return s, false
+		}
+		if n == -1 {
+			return rest, true
+		}
+		if len(rest) < n+2 || rest[n:n+2] != "\r\n" {
+			return s, false
+		}
+		return rest[n+2:], true
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil || n < -1 {
+			return s, false
+		}
+		for j := 0; j < n; j++ {
+			var ok bool
+			if rest, ok = skipReply(rest); !ok {
+				return s, false
+			}
+		}
+		return rest, true
+	}
+	return s, false
+}
+
+func checkDatasetMemory(t *testing.T, step []string) {
+	for _, db := range server.dbs {
+		want := int64(0)
+		db.dict.Range(func(key, val *Obj) bool {
+			want += keyMemory(key) + objectComputeSize(val, memoryUsageDefaultSamples)
+			return true
+		})
+		if db.usedMemory != want {
+			t.Logf("%v: db%d expect usedMemory %d, but got %d", step, db.id, want, db.usedMemory)
+			t.FailNow()
+		}
+	}
+}
+
+func Test_MemoryAccounting(t *testing.T) {
+	c := newTestClient()
+	// 元素大小相同，抽样估算与全部统计一致
+	steps := [][]string{
+		{"SET", "s", "hello"},
+		{"SET", "s", "hello world"},
+		{"SET", "n", "12345678"},
+		{"RPUSH", "l", "a", "b", "c", "d", "e", "f", "g", "h", "i"},
+		{"LPOP", "l", "3"},
+		{"HSET", "h", "f1", "v1", "f2", "v2"},
+		{"SADD", "set", "1", "2", "3"},
+		{"SADD", "set", "x"},
+		{"ZADD", "z", "1", "a", "2", "b"},
+		{"EXPIRE", "s", "100"},
+		{"RENAME", "s", "s2"},
+		{"COPY", "l", "l2"},
+		{"MOVE", "h", "1"},
+		{"LMOVE", "l", "l3", "LEFT", "RIGHT"},
+		{"SINTERSTORE", "set2", "set"},
+		{"ZUNIONSTORE", "z2", "1", "z"},
+		{"DEL", "n", "none"},
+		{"RPOP", "l3"},
+		{"SWAPDB", "0", "1"},
+		{"SELECT", "1"},
+		{"FLUSHDB"},
+	}
+	for _, step := range steps {
+		execCmd(c, step...)
+		checkDatasetMemory(t, step)
+	}
+	// 大容器转换编码后仍然一致
+	for i := 0; i < 300; i++ {
+		execCmd(c, "HSET", "big", fmt.Sprintf("f%03d", i), "v")
+		execCmd(c, "ZADD", "bigz", strconv.Itoa(i), fmt.Sprintf("m%03d", i))
+	}
+	checkDatasetMemory(t, []string{"HSET big"})
+
+	execCmd(c, "SELECT", "0")
+	execCmd(c, "FLUSHALL")
+	if datasetMemory() != 0 {
+		t.Logf("expect empty dataset, but got %d", datasetMemory())
+		t.FailNow()
+	}
+}
+
+func Test_MemoryCmd(t *testing.T) {
+	c := newTestClient()
+	execCmd(c, "SET", "k", "v")
+	usage := fmt.Sprintf(":%d\r\n", objSize+1+objSize+1+hEntrySize)
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"MEMORY", "USAGE", "k"}, usage},
+		{[]string{"MEMORY", "USAGE", "k", "SAMPLES", "0"}, usage},
+		{[]string{"MEMORY", "USAGE", "none"}, "$-1\r\n"},
+		{[]string{"MEMORY", "USAGE", "k", "SAMPLES", "-1"}, "-ERR syntax error\r\n"},
+		{[]string{"MEMORY", "USAGE", "k", "COUNT", "1"}, "-ERR syntax error\r\n"},
+		{[]string{"MEMORY", "NOPE"}, "-ERR unknown subcommand or wrong number of arguments for 'NOPE'\r\n"},
+	}
+	for _, cs := range cases {
+		if got := execCmd(c, cs.args...); got != cs.want {
+			t.Logf("%v expect %q, but got %q", cs.args, cs.want, got)
+			t.FailNow()
+		}
+	}
+
+	// SAMPLES 0 统计全部元素，元素大小不同时和抽样结果不同
+	execCmd(c, "RPUSH", "l", "a", "b", "c", "d", "e", "ffffffffffffffffffff")
+	all := execCmd(c, "MEMORY", "USAGE", "l", "SAMPLES", "0")
+	sampled := execCmd(c, "MEMORY", "USAGE", "l")
+	if all == sampled {
+		t.Logf("expect SAMPLES 0 differs from default sampling, but both %q", all)
+		t.FailNow()
+	}
+
+	stats := execCmd(c, "MEMORY", "STATS")
+	// 数组长度必须和实际元素个数一致，否则后续回复都会错位
+	if rest, ok := skipReply(stats); !ok || rest != "" {
+		t.Logf("expect MEMORY STATS is a single well-formed reply, but got %q", stats)
+		t.FailNow()
+	}
+	for _, field := range []string{"peak.allocated", "total.allocated", "db.0", "overhead.hashtable.main", "keys.count\r\n:2\r\n", "dataset.bytes"} {
+		if !strings.Contains(stats, field) {
+			t.Logf("expect MEMORY STATS contains %q, but got %q", field, stats)
+			t.FailNow()
+		}
+	}
+	if got := execCmd(c, "MEMORY", "DOCTOR"); !strings.Contains(got, "using very little memory") {
+		t.Logf("expect empty instance report, but got %q", got)
+		t.FailNow()
+	}
+	if info := genInfoString("memory"); !strings.Contains(info, fmt.Sprintf("used_memory_dataset:%d\r\n", datasetMemory())) {
+		t.Logf("expect used_memory_dataset in INFO, but got %q", info)
+		t.FailNow()
+	}
+}
+
+// 等待后台释放完成，返回期间释放的对象个数
+func waitLazyfree(t *testing.T, before int64) int64 {
+	for i := 0; atomic.LoadInt64(&lazyfreePendingObjects) > 0; i++ {
+		if i > 1000 {
+			t.Logf("lazyfree pending objects not drained")
+			t.FailNow()
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return atomic.LoadInt64(&lazyfreedObjects) - before
+}
+
+func Test_LazyFree(t *testing.T) {
+	c := newTestClient()
+	defer func() {
+		server.lazyfreeLazyUserDel, server.lazyfreeLazyServer, server.lazyfreeLazyExpire = false, false, false
+	}()
+	fill := func(key string, n int) {
+		args := []string{"RPUSH", key}
+		for i := 0; i < n; i++ {
+			args = append(args, "v"+strconv.Itoa(i))
+		}
+		execCmd(c, args...)
+	}
+
+	// 元素少于阈值时在主线程释放
+	freed := atomic.LoadInt64(&lazyfreedObjects)
+	fill("small", lazyfreeThreshold)
+	if got := execCmd(c, "UNLINK", "small"); got != ":1\r\n" || waitLazyfree(t, freed) != 0 {
+		t.Logf("expect small value freed synchronously, got %q", got)
+		t.FailNow()
+	}
+
+	// UNLINK 大对象在后台释放元素的引用，COPY 出的list共享元素不受影响
+	fill("big", 1000)
+	execCmd(c, "COPY", "big", "copy")
+	dq := c.db.dict.Get(NewObjectFromStr("big")).ptr.(*Deque)
+	elem := c.db.dict.Get(NewObjectFromStr("copy")).ptr.(*Deque).Index(999)
+	if elem.getRefCount() != 2 {
+		t.Logf("expect element shared by copy, but refcount %d", elem.getRefCount())
+		t.FailNow()
+	}
+	freed = atomic.LoadInt64(&lazyfreedObjects)
+	if got := execCmd(c, "UNLINK", "big"); got != ":1\r\n" || waitLazyfree(t, freed) != 1 {
+		t.Logf("expect big value freed in background, got %q", got)
+		t.FailNow()
+	}
+	if dq.len != 0 || dq.buf != nil || elem.getRefCount() != 1 {
+		t.Logf("expect deque released, element refcount %d", elem.getRefCount())
+		t.FailNow()
+	}
+	if got := execCmd(c, "LINDEX", "copy", "999"); got != "$4\r\nv999\r\n" {
+		t.Logf("expect copy intact, but got %q", got)
+		t.FailNow()
+	}
+
+	// DEL 默认同步释放，开启 lazyfree-lazy-user-del 后后台释放
+	fill("big", 1000)
+	freed = atomic.LoadInt64(&lazyfreedObjects)
+	execCmd(c, "DEL", "big")
+	if n := waitLazyfree(t, freed); n != 0 {
+		t.Logf("expect DEL freed synchronously, but %d lazyfreed", n)
+		t.FailNow()
+	}
+	server.lazyfreeLazyUserDel = true
+	execCmd(c, "DEL", "copy")
+	if n := waitLazyfree(t, freed); n != 1 {
+		t.Logf("expect DEL freed in background, but %d lazyfreed", n)
+		t.FailNow()
+	}
+
+	// 覆盖和过期删除
+	server.lazyfreeLazyServer, server.lazyfreeLazyExpire = true, true
+	fill("big", 1000)
+	fill("big2", 1000)
+	freed = atomic.LoadInt64(&lazyfreedObjects)
+	execCmd(c, "SET", "big", "v")
+	setExpire(c.db, NewObjectFromStr("big2"), ae.GetUnixTime()-1)
+	execCmd(c, "EXISTS", "big2")
+	if n := waitLazyfree(t, freed); n != 2 {
+		t.Logf("expect overwrite and expire freed in background, but %d lazyfreed", n)
+		t.FailNow()
+	}
+
+	// FLUSHALL ASYNC 把整个db交给后台
+	for i := 0; i < 100; i++ {
+		execCmd(c, "SET", fmt.Sprintf("k%d", i), "v")
+	}
+	freed = atomic.LoadInt64(&lazyfreedObjects)
+	execCmd(c, "FLUSHALL", "ASYNC")
+	if n := waitLazyfree(t, freed); n != 2 || execCmd(c, "DBSIZE") != ":0\r\n" {
+		t.Logf("expect keyspace and expires freed in background, but %d lazyfreed", n)
+		t.FailNow()
+	}
+	info := genInfoString("")
+	if !strings.Contains(info, "lazyfree_pending_objects:0\r\n") || !strings.Contains(info, "lazyfreed_objects:") {
+		t.Logf("expect lazyfree stats in INFO, but got %q", info)
+		t.FailNow()
+	}
+
+	// 后台释放 dict 时执行 free 和 destructor 钩子
+	var keyFrees, valFrees int64
+	dt := StrDictType
+	dt.KeyDestructorFn = func(key *Obj) { atomic.AddInt64(&keyFrees, 1); key.decrRefCount() }
+	dt.ValDestructorFn = func(val *Obj) { atomic.AddInt64(&valFrees, 1); val.decrRefCount() }
+	dict := NewDict(dt)
+	for i := 0; i < 100; i++ {
+		_ = dict.Add(NewObjectFromStr(fmt.Sprintf("k%d", i)), NewObjectFromStr("v"))
+	}
+	freed = atomic.LoadInt64(&lazyfreedObjects)
+	lazyfreeSubmit(dict)
+	if n := waitLazyfree(t, freed); n != 1 || atomic.LoadInt64(&keyFrees) != 100 || atomic.LoadInt64(&valFrees) != 100 {
+		t.Logf("expect destructors called in background, but key %d val %d", atomic.LoadInt64(&keyFrees), atomic.LoadInt64(&valFrees))
+		t.FailNow()
+	}
+}
+
+func Test_ObjectCmd(t *testing.T) {
+	c := newTestClient()
+	defer func() {
+		server.maxmemoryPolicy = maxmemory_NoEviction
+	}()
+	server.maxmemoryPolicy = maxmemory_AllKeysLRU
+	server.lfuLogFactor, server.lfuDecayTime = 10, 1
+	server.lruclock = 1000
+	execCmd(c, "SET", "s", "abc")
+	execCmd(c, "SET", "n", "7")
+	execCmd(c, "RPUSH", "l", "a")
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"OBJECT", "ENCODING", "s"}, "$6\r\nembstr\r\n"},
+		{[]string{"OBJECT", "ENCODING", "l"}, "$5\r\ndeque\r\n"},
+		{[]string{"OBJECT", "REFCOUNT", "s"}, ":1\r\n"},
+		{[]string{"OBJECT", "REFCOUNT", "n"}, fmt.Sprintf(":%d\r\n", sharedRefCount)},
+		{[]string{"OBJECT", "REFCOUNT", "none"}, "$-1\r\n"},
+		{[]string{"OBJECT", "IDLETIME", "s"}, ":0\r\n"},
+		{[]string{"OBJECT", "FREQ", "s"}, "-ERR An LFU maxmemory policy is not selected, access frequency not tracked. " +
+			"Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.\r\n"},
+		{[]string{"OBJECT", "NOPE", "s"}, "-ERR unknown subcommand or wrong number of arguments for 'NOPE'\r\n"},
+		{[]string{"OBJECT", "ENCODING"}, "-ERR unknown subcommand or wrong number of arguments for 'ENCODING'\r\n"},
+	}
+	for _, cs := range cases {
+		if got := execCmd(c, cs.args...); got != cs.want {
+			t.Logf("%v expect %q, but got %q", cs.args, cs.want, got)
+			t.FailNow()
+		}
+	}
+	if got := execCmd(c, "OBJECT", "HELP"); !strings.HasPrefix(got, "*13\r\n+OBJECT <subcommand>") {
+		t.Logf("expect help lines, but got %q", got)
+		t.FailNow()
+	}
+
+	// OBJECT 不更新访问时间，读写命令更新
+	server.lruclock = 1010
+	for i := 0; i < 2; i++ {
+		if got := execCmd(c, "OBJECT", "IDLETIME", "s"); got != ":10\r\n" {
+			t.Logf("expect idle 10s, but got %q", got)
+			t.FailNow()
+		}
+	}
+	execCmd(c, "GET", "s")
+	if got := execCmd(c, "OBJECT", "IDLETIME", "s"); got != ":0\r\n" {
+		t.Logf("expect idle reset by GET, but got %q", got)
+		t.FailNow()
+	}
+
+	// LFU 策略下新对象计数器为初值，访问后增长
+	server.maxmemoryPolicy = maxmemory_AllKeysLFU
+	execCmd(c, "SET", "f", "v")
+	if got := execCmd(c, "OBJECT", "FREQ", "f"); got != fmt.Sprintf(":%d\r\n", lfuInitVal) {
+		t.Logf("expect initial freq, but got %q", got)
+		t.FailNow()
+	}
+	if got := execCmd(c, "OBJECT", "IDLETIME", "f"); !strings.HasPrefix(got, "-ERR An LFU maxmemory policy is selected") {
+		t.Logf("expect IDLETIME error under LFU, but got %q", got)
+		t.FailNow()
+	}
+	for i := 0; i < 200; i++ {
+		execCmd(c, "GET", "f")
+	}
+	freq := execCmd(c, "OBJECT", "FREQ", "f")
+	if freq == fmt.Sprintf(":%d\r\n", lfuInitVal) || execCmd(c, "OBJECT", "FREQ", "f") != freq {
+		t.Logf("expect freq increased by GET only, but got %q", freq)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// Intset 有序整数数组，全是整数的小集合使用，查找 O(logn)
+type Intset struct {
+	contents []int64
+}
+
+func NewIntset() *Intset {
+	return &Intset{contents: make([]int64, 0, 8)}
+}
+
+func (is *Intset) Len() int {
+	return len(is.contents)
+}
+
+// search 返回 v 的位置，不存在时返回应插入的位置
+func (is *Intset) search(v int64) (int, bool) {
+	i := sort.Search(len(is.contents), func(i int) bool {
+		return is.contents[i] >= v
+	})
+	return i, i < len(is.contents) && is.contents[i] == v
+}
+
+func (is *Intset) Find(v int64) bool {
+	_, ok := is.search(v)
+	return ok
+}
+
+// Add 插入 v，已存在返回 false
+func (is *Intset) Add(v int64) bool {
+	i, ok := is.search(v)
+	if ok {
+		return false
+	}
+	is.contents = append(is.contents, 0)
+	copy(is.contents[i+1:], is.contents[i:])
+	is.contents[i] = v
+	return true
+}
+
+// Remove 删除 v，不存在返回 false
+func (is *Intset) Remove(v int64) bool {
+	i, ok := is.search(v)
+	if !ok {
+		return false
+	}
+	is.contents = append(is.contents[:i], is.contents[i+1:]...)
+	return true
+}
+
+func (is *Intset) Get(i int) int64 {
+	return is.contents[i]
+}
+
+func (is *Intset) Random() int64 {
+	return is.contents[rand.Intn(len(is.contents))]
+}
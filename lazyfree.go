@@ -0,0 +1,68 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+/*
+   后台释放大对象，参考 redis lazyfree.c
+   1. 主线程只把value从keyspace摘下来，元素很多的value交给后台goroutine释放元素的引用
+   2. 后台goroutine和同步释放走同样的路径：decrRefCount / Dict.Release，dict 的 free 和 destructor 钩子都会执行
+   3. 交给后台的对象主线程不再访问，元素可能被其他容器共享，引用计数使用原子操作
+   4. UNLINK、FLUSHALL ASYNC 总是后台释放，过期、淘汰、隐式删除、DEL、FLUSH 由 lazyfree-lazy-* 配置决定
+*/
+
+const (
+	lazyfreeThreshold = 64   // 释放代价超过该值才放到后台
+	lazyfreeQueueSize = 1024 // 任务队列长度，满了之后主线程等待
+)
+
+var (
+	lazyfreeOnce  sync.Once
+	lazyfreeQueue chan interface{}
+
+	lazyfreePendingObjects int64 // 等待后台释放的对象个数，原子操作
+	lazyfreedObjects       int64 // 后台已经释放的对象个数，原子操作
+)
+
+// lazyfreeGetFreeEffort 释放对象的代价，紧凑编码和字符串连续存放，代价为 1
+func lazyfreeGetFreeEffort(obj *Obj) int {
+	switch obj.encoding {
+	case GEncoding_Deque:
+		return obj.ptr.(*Deque).Len()
+	case GEncoding_Hashtable:
+		return obj.ptr.(*Dict).Len()
+	case GEncoding_Skiplist:
+		return obj.ptr.(*ZSet).zsl.length
+	}
+	return 1
+}
+
+// lazyfreeShouldFree value即将被删除，且只被keyspace引用、释放代价足够大时返回 true
+func lazyfreeShouldFree(obj *Obj) bool {
+	return obj.getRefCount() == 1 && lazyfreeGetFreeEffort(obj) > lazyfreeThreshold
+}
+
+// lazyfreeSubmit 交给后台释放，ptr 为引用已转交给后台的 *Obj，或者已摘下的 *Dict
+func lazyfreeSubmit(ptr interface{}) {
+	lazyfreeOnce.Do(func() {
+		lazyfreeQueue = make(chan interface{}, lazyfreeQueueSize)
+		go lazyfreeWorker()
+	})
+	atomic.AddInt64(&lazyfreePendingObjects, 1)
+	lazyfreeQueue <- ptr
+}
+
+func lazyfreeWorker() {
+	for ptr := range lazyfreeQueue {
+		switch v := ptr.(type) {
+		case *Obj:
+			v.decrRefCount()
+		case *Dict:
+			v.Release()
+		}
+		atomic.AddInt64(&lazyfreePendingObjects, -1)
+		atomic.AddInt64(&lazyfreedObjects, 1)
+	}
+}
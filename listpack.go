@@ -0,0 +1,60 @@
+package main
+
+// Listpack 小容器的紧凑编码，连续数组存储，省去hash表/跳表的指针开销
+// hash 按 field,value 交替存储，zset 按 (score, member) 有序、member,score 交替存储
+// 查找为 O(n)，元素个数受 *-max-listpack-entries 限制
+type Listpack struct {
+	entries []*Obj
+}
+
+func NewListpack() *Listpack {
+	return &Listpack{entries: make([]*Obj, 0, 8)}
+}
+
+// Len entry 个数 (hash 为 field 数 * 2)
+func (lp *Listpack) Len() int {
+	return len(lp.entries)
+}
+
+// FindPair 在 [0, 2, 4...] 位置查找 key，返回下标，找不到返回 -1
+func (lp *Listpack) FindPair(key *Obj) int {
+	for i := 0; i+1 < len(lp.entries); i += 2 {
+		if Equal(lp.entries[i], key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// AppendPair 追加 key,val，增加引用计数
+func (lp *Listpack) AppendPair(key, val *Obj) {
+	key.incrRefCount()
+	val.incrRefCount()
+	lp.entries = append(lp.entries, key, val)
+}
+
+// Replace 替换下标 i 处的entry
+func (lp *Listpack) Replace(i int, val *Obj) {
+	val.incrRefCount()
+	lp.entries[i].decrRefCount()
+	lp.entries[i] = val
+}
+
+// DeletePair 删除下标 i 处的 key,val
+func (lp *Listpack) DeletePair(i int) {
+	lp.entries[i].decrRefCount()
+	lp.entries[i+1].decrRefCount()
+	n := len(lp.entries)
+	copy(lp.entries[i:], lp.entries[i+2:])
+	lp.entries[n-1], lp.entries[n-2] = nil, nil
+	lp.entries = lp.entries[:n-2]
+}
+
+// InsertPair 在下标 i 处插入 key,val
+func (lp *Listpack) InsertPair(i int, key, val *Obj) {
+	key.incrRefCount()
+	val.incrRefCount()
+	lp.entries = append(lp.entries, nil, nil)
+	copy(lp.entries[i+2:], lp.entries[i:])
+	lp.entries[i], lp.entries[i+1] = key, val
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"time"
 
 	"github.com/draymonders/gmem/ae"
 	"github.com/draymonders/gmem/conf"
@@ -12,6 +11,7 @@ import (
 
 const (
 	MaxClientQueryBufferLen = 1024 * 4 // 4KB
+	serverCronInterval      = 10       // serverCron 执行间隔，单位ms
 )
 
 type cmdType int // 请求Command类型
@@ -29,7 +29,36 @@ type Server struct {
 
 	eventLoop *ae.EventLoop   // aeLoop
 	clients   map[int]*Client // fd -> client
-	db        *DB             // storage
+	dbs       []*DB           // storage，按编号索引
+
+	readyKeys []*readyKey // 有阻塞client等待、且被push过的key，beforeSleep 时处理
+
+	// 内存淘汰
+	maxmemory        int64  // 内存上限(字节)，0 表示不限制
+	maxmemoryPolicy  int    // maxmemory_*
+	maxmemorySamples int    // 每次淘汰抽样的key个数
+	lfuLogFactor     int    // LFU 计数器增长的对数因子
+	lfuDecayTime     int    // LFU 计数器衰减周期(分钟)
+	lruclock         uint32 // serverCron 中更新的LRU时钟
+
+	activeRehashing bool // serverCron 中主动推进db的rehash
+
+	// 后台释放，见 lazyfree.go
+	lazyfreeLazyEviction bool
+	lazyfreeLazyExpire   bool
+	lazyfreeLazyServer   bool
+	lazyfreeLazyUserDel  bool
+	lazyfreeLazyFlush    bool
+
+	// 统计
+	statKeyspaceHits   int64 // 读命令命中key的次数
+	statKeyspaceMisses int64 // 读命令未命中key的次数
+	statExpiredKeys    int64 // 过期删除的key个数
+	statEvictedKeys    int64 // 内存淘汰删除的key个数
+	statPeakMemory     int64 // used_memory 的峰值
+
+	statExpiredStalePerc           float64 // 主动过期抽样中已过期key的比例(%)，滑动平均
+	statExpiredTimeCapReachedCount int64   // 主动过期因超时提前退出的次数
 }
 
 type Client struct {
@@ -46,11 +75,24 @@ type Client struct {
 
 	args  []*Obj // args -> reply
 	reply *List
+
+	flags  int           // clientFlag_*
+	bstate blockingState // 阻塞命令的状态
 }
 
+const (
+	clientFlag_Blocked = 1 << 0 // 阻塞在 BLPOP 等命令上
+)
+
 type DB struct {
+	id      int
 	expires *Dict // key是否过期
 	dict    *Dict // key -> gObj
+
+	usedMemory int64 // key和value占用的内存，写入删除时维护
+
+	blockingKeys map[string][]*Client // key -> 按阻塞先后排列的clients
+	readyKeys    map[string]struct{}  // 已加入 server.readyKeys 的key，去重
 }
 
 func main() {
@@ -76,11 +118,25 @@ func main() {
 
 func initServer(cf *conf.Config) (err error) {
 	server.port = cf.Port
+	if server.maxmemoryPolicy, err = parseMaxmemoryPolicy(cf.MaxmemoryPolicy); err != nil {
+		return err
+	}
+	server.maxmemory = cf.Maxmemory
+	server.maxmemorySamples = cf.MaxmemorySamples
+	server.lfuLogFactor = cf.LfuLogFactor
+	server.lfuDecayTime = cf.LfuDecayTime
+	server.lruclock = getLRUClock()
+	server.activeRehashing = cf.ActiveRehashing
+	server.lazyfreeLazyEviction = cf.LazyfreeLazyEviction
+	server.lazyfreeLazyExpire = cf.LazyfreeLazyExpire
+	server.lazyfreeLazyServer = cf.LazyfreeLazyServer
+	server.lazyfreeLazyUserDel = cf.LazyfreeLazyUserDel
+	server.lazyfreeLazyFlush = cf.LazyfreeLazyFlush
 	// 1. 初始化server数据结构
 	server.clients = make(map[int]*Client)
-	server.db = &DB{
-		expires: NewDict(DictType{HashFn: Hash, EqualFn: Equal}),
-		dict:    NewDict(DictType{HashFn: Hash, EqualFn: Equal}),
+	server.dbs = make([]*DB, cf.Databases)
+	for i := range server.dbs {
+		server.dbs[i] = newDB(i)
 	}
 	// 2. 建立tcp链接，获取fd
 	if server.fd, err = TcpServer(server.port); err != nil {
@@ -97,27 +153,33 @@ func initServer(cf *conf.Config) (err error) {
 		return err
 	}
 	// 3.2 监听时间事件循环
-	server.eventLoop.AddTimeEvent(10, ae.TimeEventType_Cycle, serverCron, nil)
+	server.eventLoop.AddTimeEvent(serverCronInterval, ae.TimeEventType_Cycle, serverCron, nil)
+	// 3.3 每轮等待事件前的处理
+	server.eventLoop.SetBeforeSleepProc(beforeSleep)
 	return nil
 }
 
-// 定时清理过期key
+// 定时任务
 func serverCron(extra interface{}) {
-	const scanSize = 1
-	unixTs := time.Now().Unix()
-	for idx := 0; idx < scanSize; idx++ {
-		if entry := server.db.expires.RandomGet(); entry != nil {
-			expireTs, err := entry.val.ToInt64()
-			if err != nil || expireTs == -1 {
-				continue
-			}
-			if unixTs >= expireTs {
-				server.db.expires.Del(entry.key)
-				server.db.dict.Del(entry.key)
-			}
-		}
+	server.lruclock = getLRUClock()
+	// 更新内存峰值
+	usedMemory()
+	// 上次淘汰超时退出时继续淘汰
+	performEvictions()
+	// 推进空闲db的rehash
+	if server.activeRehashing {
+		activeRehashCycle()
 	}
+	// 主动清理过期key
+	activeExpireCycle(activeExpireCycle_Slow)
+}
 
+// 事件循环进入等待前调用
+func beforeSleep() {
+	// 唤醒阻塞在已就绪key上的client
+	handleClientsBlockedOnKeys()
+	// 过期key积压时快速清理一轮
+	activeExpireCycle(activeExpireCycle_Fast)
 }
 
 func acceptHandler(extra interface{}) {
@@ -130,7 +192,7 @@ func acceptHandler(extra interface{}) {
 
 	client := &Client{
 		fd:       cfd, // client default db
-		db:       server.db,
+		db:       server.dbs[0],
 		queryBuf: make([]byte, 0),
 		args:     make([]*Obj, 0),
 		reply:    NewList(ListType{EqualFn: ListEqualFn}),
@@ -172,7 +234,7 @@ func readQueryFromClient(extra interface{}) {
 
 func processInputBuffer(c *Client) (err error) {
 	// 解析命令，将 queryBuf -> c.args
-	for c.queryLen > 0 { // 先处理下bulk
+	for c.queryLen > 0 && c.flags&clientFlag_Blocked == 0 { // 先处理下bulk，阻塞中的client等唤醒后再处理
 		c.cmdType = parseCmdType(c)
 		var ok bool
 		switch c.cmdType {
@@ -210,15 +272,19 @@ func processCommand(c *Client) (err error) {
 
 	if cmd := lookupCmd(c); cmd != nil {
 		if err := checkLimit(c, cmd); err != nil { // 校验参数个数
-			c.reply.Add(NewObjectFromStr(fmt.Sprintf(respFmt, err.Error())))
-			return nil
+			c.addReplyError(err.Error())
+		} else if server.maxmemory > 0 && performEvictions() == evictFail && cmd.flags&cmdFlag_DenyOom != 0 {
+			// 淘汰后仍然超过内存上限，拒绝可能增加内存的命令
+			c.addReplyError("OOM command not allowed when used memory > 'maxmemory'.")
+		} else {
+			cmd.fn(c, cmd)
 		}
-		cmd.fn(c, cmd)
-		return nil
-	}
-	if len(c.args) > 0 { // 找不到命令 对应的回调
+	} else if len(c.args) > 0 { // 找不到命令 对应的回调
 		c.reply.Add(NewObjectFromStr(fmt.Sprintf("+<not support %v method>\r\n", c.args[0].ToStr())))
 	}
+	// 命令执行完，重新计算修改过的value大小，释放本次的全部参数
+	updateTouchedKeysMemory()
+	freeClientArgs(c, -1)
 	return nil
 }
 
@@ -263,6 +329,9 @@ func addReply(extra interface{}) {
 }
 
 func freeClient(c *Client) {
+	if c.flags&clientFlag_Blocked != 0 {
+		unblockClient(c)
+	}
 	// delete read & write file event
 	_ = server.eventLoop.DelEvent(c.fd, ae.FileEventType_Readable)
 	_ = server.eventLoop.DelEvent(c.fd, ae.FileEventType_Writeable)
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"math"
+	"unsafe"
+)
+
+/*
+   内存统计，参考 redis object.c objectComputeSize
+   1. 按编码估算对象大小，容器只抽样前 samples 个元素，按平均大小乘以元素个数
+   2. 每个db维护 usedMemory 计数：key/value 写入、删除时增减，value 记录计入时的大小 (Obj.memSize)
+   3. 写命令通过 lookupKeyWrite/dbAdd 取到的value在命令结束后重新估算，差值计入计数
+*/
+
+const memoryUsageDefaultSamples = 5 // 默认抽样的元素个数
+
+var (
+	objSize       = int64(unsafe.Sizeof(Obj{}))
+	ptrSize       = int64(unsafe.Sizeof(uintptr(0)))
+	dictSize      = int64(unsafe.Sizeof(Dict{}))
+	hTableSize    = int64(unsafe.Sizeof(hTable{}))
+	hEntrySize    = int64(unsafe.Sizeof(hEntry{}))
+	dequeSize     = int64(unsafe.Sizeof(Deque{}))
+	listpackSize  = int64(unsafe.Sizeof(Listpack{}))
+	intsetSize    = int64(unsafe.Sizeof(Intset{}))
+	zsetSize      = int64(unsafe.Sizeof(ZSet{}))
+	zslSize       = int64(unsafe.Sizeof(zskiplist{}))
+	zslNodeSize   = int64(unsafe.Sizeof(zskiplistNode{}))
+	zslLevelSize  = int64(unsafe.Sizeof(zskiplistLevel{}))
+	listSize      = int64(unsafe.Sizeof(List{}))
+	listNodeSize  = int64(unsafe.Sizeof(Node{}))
+	float64Size   = int64(unsafe.Sizeof(float64(0)))
+	zslAvgLevels  = 1 / (1 - zskiplistP) // 跳表节点的平均层数
+	sharedObjSize = int64(0)             // 共享对象不归属任何key
+)
+
+// stringObjectSize 字符串对象大小，共享整数不计，int 编码和 zset 分数只有对象本身
+func stringObjectSize(obj *Obj) int64 {
+	if obj.isShared() {
+		return sharedObjSize
+	}
+	if s, ok := obj.ptr.(string); ok {
+		return objSize + int64(len(s))
+	}
+	return objSize
+}
+
+// sampledSize 按前 samples 个元素的平均大小估算 n 个元素的大小，samples 为 0 时统计全部
+func sampledSize(n, samples int, rangeFn func(fn func(size int64) bool)) int64 {
+	if n == 0 {
+		return 0
+	}
+	total, sampled := int64(0), 0
+	rangeFn(func(size int64) bool {
+		total += size
+		sampled++
+		return samples == 0 || sampled < samples
+	})
+	if sampled == 0 {
+		return 0
+	}
+	return total * int64(n) / int64(sampled)
+}
+
+// dictTablesSize Dict 结构、hash表bucket和entry的大小，不含key/val
+func dictTablesSize(d *Dict) int64 {
+	size := dictSize
+	for i := 0; i <= 1; i++ {
+		if d.ht[i] != nil {
+			size += hTableSize + int64(d.ht[i].size)*ptrSize
+		}
+	}
+	return size + int64(d.Len())*hEntrySize
+}
+
+// dictComputeSize Dict 的大小，key/val 按抽样估算
+func dictComputeSize(d *Dict, samples int) int64 {
+	return dictTablesSize(d) + sampledSize(d.Len(), samples, func(fn func(size int64) bool) {
+		d.rangeReadOnly(func(key, val *Obj) bool {
+			size := stringObjectSize(key)
+			if val != nil {
+				size += stringObjectSize(val)
+			}
+			return fn(size)
+		})
+	})
+}
+
+// objectComputeSize 估算value占用的内存，samples 为抽样的元素个数，0 表示全部统计
+func objectComputeSize(obj *Obj, samples int) int64 {
+	switch obj.encoding {
+	case GEncoding_Raw, GEncoding_Embstr, GEncoding_Int:
+		return stringObjectSize(obj)
+	case GEncoding_Deque:
+		dq := obj.ptr.(*Deque)
+		return objSize + dequeSize + int64(len(dq.buf))*ptrSize +
+			sampledSize(dq.Len(), samples, func(fn func(size int64) bool) {
+				dq.Range(0, dq.Len()-1, func(i int, val *Obj) bool {
+					return fn(stringObjectSize(val))
+				})
+			})
+	case GEncoding_Listpack:
+		lp := obj.ptr.(*Listpack)
+		return objSize + listpackSize + int64(cap(lp.entries))*ptrSize +
+			sampledSize(lp.Len(), samples, func(fn func(size int64) bool) {
+				for _, entry := range lp.entries {
+					if !fn(stringObjectSize(entry)) {
+						return
+					}
+				}
+			})
+	case GEncoding_Intset:
+		is := obj.ptr.(*Intset)
+		return objSize + intsetSize + int64(cap(is.contents))*8
+	case GEncoding_Hashtable:
+		return objSize + dictComputeSize(obj.ptr.(*Dict), samples)
+	case GEncoding_Skiplist:
+		zs := obj.ptr.(*ZSet)
+		nodeSize := zslNodeSize + int64(zslAvgLevels*float64(zslLevelSize))
+		// member 对象由跳表和dict共享，只在dict中统计一次，dict的val为分数
+		return objSize + zsetSize + zslSize + int64(zs.zsl.length+1)*nodeSize +
+			dictTablesSize(zs.dict) + int64(zs.dict.Len())*(objSize+float64Size) +
+			sampledSize(zs.dict.Len(), samples, func(fn func(size int64) bool) {
+				zs.dict.rangeReadOnly(func(member, _ *Obj) bool {
+					return fn(stringObjectSize(member))
+				})
+			})
+	}
+	return objSize
+}
+
+// listComputeSize 回复链表占用的内存
+func listComputeSize(l *List) int64 {
+	size := listSize + int64(l.Len())*listNodeSize
+	for cur := l.Head; cur != nil; cur = cur.Next {
+		size += stringObjectSize(cur.Val)
+	}
+	return size
+}
+
+// keyMemory key对象的大小，entry 在db hash表的开销中统计
+func keyMemory(key *Obj) int64 {
+	return objSize + int64(len(key.ToStr()))
+}
+
+// accountValue 重新估算value大小并返回和上次计入的差值
+func accountValue(val *Obj) int64 {
+	size := objectComputeSize(val, memoryUsageDefaultSamples)
+	if size > math.MaxUint32 {
+		size = math.MaxUint32
+	}
+	delta := size - int64(val.memSize)
+	val.memSize = uint32(size)
+	return delta
+}
+
+// memTouchedKey 写命令取到的key，命令结束后重新计算value大小
+type memTouchedKey struct {
+	db  *DB
+	key *Obj
+	val *Obj
+}
+
+var memTouchedKeys []memTouchedKey
+
+// touchKeyMemory 记录可能被修改的value
+func touchKeyMemory(db *DB, key, val *Obj) {
+	for _, t := range memTouchedKeys {
+		if t.db == db && t.val == val && Equal(t.key, key) {
+			return
+		}
+	}
+	key.incrRefCount()
+	memTouchedKeys = append(memTouchedKeys, memTouchedKey{db: db, key: key, val: val})
+}
+
+// updateTouchedKeysMemory 重新计算本次修改过的value，value已被删除或替换时删除/替换时已经计入
+func updateTouchedKeysMemory() {
+	for _, t := range memTouchedKeys {
+		if t.db.dict.Get(t.key) == t.val {
+			t.db.usedMemory += accountValue(t.val)
+		}
+		t.key.decrRefCount()
+	}
+	memTouchedKeys = memTouchedKeys[:0]
+}
+
+// dbOverheadMemory db的hash表自身和过期时间占用的内存
+func dbOverheadMemory(db *DB) (main, expires int64) {
+	return dictTablesSize(db.dict), dictTablesSize(db.expires) + int64(db.expires.Len())*objSize
+}
+
+// datasetMemory 全部db中key和value占用的内存
+func datasetMemory() int64 {
+	used := int64(0)
+	for _, db := range server.dbs {
+		used += db.usedMemory
+	}
+	return used
+}
+
+// usedMemory 数据集和db hash表占用的内存，内存淘汰以此为准
+func usedMemory() int64 {
+	used := datasetMemory()
+	for _, db := range server.dbs {
+		main, expires := dbOverheadMemory(db)
+		used += main + expires
+	}
+	if used > server.statPeakMemory {
+		server.statPeakMemory = used
+	}
+	return used
+}
+
+// clientsMemory 全部client的查询缓冲和回复占用的内存
+func clientsMemory() int64 {
+	size := int64(0)
+	for _, c := range server.clients {
+		size += int64(cap(c.queryBuf)) + listComputeSize(c.reply)
+	}
+	return size
+}
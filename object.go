@@ -1,6 +1,10 @@
 package main
 
-import "strconv"
+import (
+	"math"
+	"strconv"
+	"sync/atomic"
+)
 
 type GVal interface{}
 
@@ -14,17 +18,86 @@ const (
 	GType_ZSet = 5
 )
 
+// GEncoding 对象的底层编码
+type GEncoding int
+
+const (
+	GEncoding_Raw       GEncoding = 0 // string
+	GEncoding_Int       GEncoding = 1 // int64
+	GEncoding_Embstr    GEncoding = 2 // 短string
+	GEncoding_Deque     GEncoding = 3 // list: 环形缓冲区双端队列
+	GEncoding_Listpack  GEncoding = 4 // hash, zset: 紧凑数组
+	GEncoding_Hashtable GEncoding = 5 // hash, set: Dict
+	GEncoding_Intset    GEncoding = 6 // set: 有序整数数组
+	GEncoding_Skiplist  GEncoding = 7 // zset: 跳表 + Dict
+)
+
+var encodingNames = map[GEncoding]string{
+	GEncoding_Raw:       "raw",
+	GEncoding_Int:       "int",
+	GEncoding_Embstr:    "embstr",
+	GEncoding_Deque:     "deque",
+	GEncoding_Listpack:  "listpack",
+	GEncoding_Hashtable: "hashtable",
+	GEncoding_Intset:    "intset",
+	GEncoding_Skiplist:  "skiplist",
+}
+
+const (
+	embstrSizeLimit = 44            // 小于等于该长度的字符串使用 embstr 编码
+	sharedIntegers  = 10000         // 共享整数对象个数 [0, sharedIntegers)
+	sharedRefCount  = math.MaxInt32 // 共享对象的引用计数，不参与增减
+)
+
+// 共享的小整数对象，只读
+var sharedInts [sharedIntegers]*Obj
+
+func init() {
+	for i := 0; i < sharedIntegers; i++ {
+		sharedInts[i] = &Obj{
+			gType:    GType_Str,
+			encoding: GEncoding_Int,
+			ptr:      int64(i),
+			refCount: sharedRefCount,
+		}
+	}
+}
+
+// Obj 值对象，除数据外记录类型、编码、引用计数和访问信息，可通过 OBJECT 命令查看
 type Obj struct {
 	gType    GType
+	encoding GEncoding
 	ptr      GVal
-	refCount int // 引用计数法
+	refCount int32  // 引用计数法，原子操作
+	lru      uint32 // LRU时钟或LFU数据，见 evict.go
+	memSize  uint32 // 作为keyspace的value时已计入 db.usedMemory 的大小，见 memory.go
 }
 
 func NewObjectFromStr(str string) *Obj {
+	encoding := GEncoding_Raw
+	if len(str) <= embstrSizeLimit {
+		encoding = GEncoding_Embstr
+	}
 	return &Obj{
 		gType:    GType_Str,
+		encoding: encoding,
 		ptr:      str,
 		refCount: 1,
+		lru:      initObjectLRU(),
+	}
+}
+
+// NewObjectFromInt64 小整数直接返回共享对象
+func NewObjectFromInt64(v int64) *Obj {
+	if v >= 0 && v < sharedIntegers {
+		return sharedInts[v]
+	}
+	return &Obj{
+		gType:    GType_Str,
+		encoding: GEncoding_Int,
+		ptr:      v,
+		refCount: 1,
+		lru:      initObjectLRU(),
 	}
 }
 
@@ -33,26 +106,166 @@ func NewObject(gType GType, ptr interface{}) *Obj {
 		gType:    gType,
 		ptr:      ptr,
 		refCount: 1,
+		lru:      initObjectLRU(),
 	}
 }
 
+func createListObject() *Obj {
+	obj := NewObject(GType_List, NewDeque())
+	obj.encoding = GEncoding_Deque
+	return obj
+}
+
+func createHashObject() *Obj {
+	obj := NewObject(GType_Dict, NewListpack())
+	obj.encoding = GEncoding_Listpack
+	return obj
+}
+
+func createIntsetObject() *Obj {
+	obj := NewObject(Gtype_Set, NewIntset())
+	obj.encoding = GEncoding_Intset
+	return obj
+}
+
+// set 的 Dict 编码只使用 key，val 为 nil
+func createSetObject() *Obj {
+	obj := NewObject(Gtype_Set, NewDict(StrDictType))
+	obj.encoding = GEncoding_Hashtable
+	return obj
+}
+
+// dupStringObject 复制字符串对象，共享整数直接返回
+func dupStringObject(obj *Obj) *Obj {
+	if obj.isShared() {
+		return obj
+	}
+	return &Obj{
+		gType:    GType_Str,
+		encoding: obj.encoding,
+		ptr:      obj.ptr,
+		refCount: 1,
+		lru:      initObjectLRU(),
+	}
+}
+
+// 引用计数使用原子操作，后台释放的容器中的元素可能同时被主线程的其他容器引用
+
 func (obj *Obj) incrRefCount() {
-	obj.refCount++
+	if obj.isShared() {
+		return
+	}
+	atomic.AddInt32(&obj.refCount, 1)
 }
 
+// decrRefCount 引用计数归零时释放容器中元素的引用
 func (obj *Obj) decrRefCount() {
-	obj.refCount--
-	if obj.refCount == 0 {
+	if obj.isShared() {
+		return
+	}
+	if atomic.AddInt32(&obj.refCount, -1) == 0 {
+		freeObject(obj)
 		obj.ptr = nil // go gc
 	}
 }
 
+func (obj *Obj) getRefCount() int32 {
+	return atomic.LoadInt32(&obj.refCount)
+}
+
+func (obj *Obj) isShared() bool {
+	return obj.getRefCount() == sharedRefCount
+}
+
+// freeObject 释放容器持有的元素，字符串没有需要释放的引用
+func freeObject(obj *Obj) {
+	switch obj.encoding {
+	case GEncoding_Deque:
+		dq := obj.ptr.(*Deque)
+		dq.Range(0, dq.Len()-1, func(i int, val *Obj) bool {
+			val.decrRefCount()
+			return true
+		})
+		*dq = Deque{}
+	case GEncoding_Listpack:
+		lp := obj.ptr.(*Listpack)
+		for _, entry := range lp.entries {
+			entry.decrRefCount()
+		}
+		lp.entries = nil
+	case GEncoding_Hashtable:
+		obj.ptr.(*Dict).Release()
+	case GEncoding_Skiplist:
+		zs := obj.ptr.(*ZSet)
+		for x := zs.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+			x.member.decrRefCount()
+		}
+		zs.zsl.header, zs.zsl.tail, zs.zsl.length = nil, nil, 0
+		zs.dict.Release()
+	}
+}
+
+// tryObjectEncoding 尝试将字符串对象转为整数编码，节省内存
+// 调用方需使用返回值替换原对象
+func tryObjectEncoding(obj *Obj) *Obj {
+	if obj.gType != GType_Str || obj.encoding == GEncoding_Int {
+		return obj
+	}
+	// 被多处引用时原地修改不安全
+	if obj.getRefCount() > 1 {
+		return obj
+	}
+	s := obj.ptr.(string)
+	v, ok := string2Int64(s)
+	if !ok {
+		return obj
+	}
+	// 按key记录访问信息时不能共享对象
+	if v >= 0 && v < sharedIntegers &&
+		(server.maxmemory == 0 || server.maxmemoryPolicy&maxmemoryFlag_NoSharedIntegers == 0) {
+		obj.decrRefCount()
+		return sharedInts[v]
+	}
+	obj.encoding = GEncoding_Int
+	obj.ptr = v
+	return obj
+}
+
+// string2Int64 只接受能无损还原的整数格式，"01" "+1" " 1" 等均不转换
+func string2Int64(s string) (int64, bool) {
+	if len(s) == 0 || len(s) > 20 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	if strconv.FormatInt(v, 10) != s {
+		return 0, false
+	}
+	return v, true
+}
+
+func (obj *Obj) encodingName() string {
+	if name, ok := encodingNames[obj.encoding]; ok {
+		return name
+	}
+	return "unknown"
+}
+
 func (obj *Obj) ToStr() string {
 	if obj == nil {
 		return "null"
 	}
 	if obj.gType == GType_Str {
-		return obj.ptr.(string)
+		switch v := obj.ptr.(type) {
+		case int64:
+			return strconv.FormatInt(v, 10)
+		case float64: // zset 的 score
+			return formatScore(v)
+		case string:
+			return v
+		}
 	}
 	return "<not support>"
 }
@@ -62,6 +275,9 @@ func (obj *Obj) ToInt64() (int64, error) {
 		return -1, nil
 	}
 	if obj.gType == GType_Str {
+		if obj.encoding == GEncoding_Int {
+			return obj.ptr.(int64), nil
+		}
 		return strconv.ParseInt(obj.ptr.(string), 10, 64)
 	}
 	return -1, nil
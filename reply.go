@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+/*
+   按 RESP 协议组装回复，追加到 c.reply
+*/
+
+var (
+	respNull      = "$-1\r\n"
+	respNullArray = "*-1\r\n"
+	respWrongType = "WRONGTYPE Operation against a key holding the wrong kind of value"
+	respSyntaxErr = "ERR syntax error"
+	respEmptyScan = "*2\r\n$1\r\n0\r\n*0\r\n"
+)
+
+func (c *Client) addReplyRaw(s string) {
+	c.reply.Add(NewObjectFromStr(s))
+}
+
+func (c *Client) addReplyStatus(s string) {
+	c.addReplyRaw("+" + s + "\r\n")
+}
+
+func (c *Client) addReplyError(msg string) {
+	c.addReplyRaw(fmt.Sprintf(respFail, msg))
+}
+
+func (c *Client) addReplyErrorf(format string, args ...interface{}) {
+	c.addReplyError(fmt.Sprintf(format, args...))
+}
+
+func (c *Client) addReplyInt(v int64) {
+	c.addReplyRaw(":" + strconv.FormatInt(v, 10) + "\r\n")
+}
+
+func (c *Client) addReplyBulk(s string) {
+	c.addReplyRaw("$" + strconv.Itoa(len(s)) + "\r\n" + s + "\r\n")
+}
+
+func (c *Client) addReplyBulkObj(obj *Obj) {
+	c.addReplyBulk(obj.ToStr())
+}
+
+func (c *Client) addReplyNull() {
+	c.addReplyRaw(respNull)
+}
+
+func (c *Client) addReplyNullArray() {
+	c.addReplyRaw(respNullArray)
+}
+
+func (c *Client) addReplyArrayLen(n int) {
+	c.addReplyRaw("*" + strconv.Itoa(n) + "\r\n")
+}
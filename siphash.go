@@ -0,0 +1,101 @@
+package main
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/bits"
+)
+
+/*
+   dict 的哈希函数，参考 redis siphash.c
+   1. SipHash-1-3：每 8 字节 1 轮压缩，结束时 3 轮，比 2-4 快，对哈希表足够安全
+   2. 进程启动时随机生成 128 位密钥，攻击者无法预先构造大量冲突的key
+   3. nocase 版本先转小写再计算，用于大小写不敏感的命令表
+*/
+
+// hashSeed SipHash 的密钥，进程启动时随机生成
+var hashSeed = newHashSeed()
+
+func newHashSeed() (seed [16]byte) {
+	if _, err := crand.Read(seed[:]); err != nil {
+		panic(err)
+	}
+	return seed
+}
+
+// DictSetHashFunctionSeed 设置哈希密钥，必须在创建任何dict之前调用，否则已有的dict无法查找
+func DictSetHashFunctionSeed(seed [16]byte) {
+	hashSeed = seed
+}
+
+// Hash dict 默认的哈希函数
+func Hash(key []byte) uint64 {
+	return siphash(key, &hashSeed, false)
+}
+
+// CaseHash 大小写不敏感的哈希函数，只处理 ASCII
+func CaseHash(key []byte) uint64 {
+	return siphash(key, &hashSeed, true)
+}
+
+func sipRound(v0, v1, v2, v3 uint64) (uint64, uint64, uint64, uint64) {
+	v0 += v1
+	v1 = bits.RotateLeft64(v1, 13)
+	v1 ^= v0
+	v0 = bits.RotateLeft64(v0, 32)
+	v2 += v3
+	v3 = bits.RotateLeft64(v3, 16)
+	v3 ^= v2
+	v0 += v3
+	v3 = bits.RotateLeft64(v3, 21)
+	v3 ^= v0
+	v2 += v1
+	v1 = bits.RotateLeft64(v1, 17)
+	v1 ^= v2
+	v2 = bits.RotateLeft64(v2, 32)
+	return v0, v1, v2, v3
+}
+
+// siphash SipHash-1-3，nocase 为 true 时按小写计算
+func siphash(in []byte, k *[16]byte, nocase bool) uint64 {
+	k0 := binary.LittleEndian.Uint64(k[0:8])
+	k1 := binary.LittleEndian.Uint64(k[8:16])
+	v0 := 0x736f6d6570736575 ^ k0
+	v1 := 0x646f72616e646f6d ^ k1
+	v2 := 0x6c7967656e657261 ^ k0
+	v3 := 0x7465646279746573 ^ k1
+
+	var buf [8]byte
+	n := len(in)
+	for ; len(in) >= 8; in = in[8:] {
+		m := binary.LittleEndian.Uint64(in[:8])
+		if nocase {
+			for i := 0; i < 8; i++ {
+				buf[i] = toLower(in[i])
+			}
+			m = binary.LittleEndian.Uint64(buf[:])
+		}
+		v3 ^= m
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0 ^= m
+	}
+
+	// 剩余不足 8 字节，最高字节为长度
+	b := uint64(n) << 56
+	for i := len(in) - 1; i >= 0; i-- {
+		c := in[i]
+		if nocase {
+			c = toLower(c)
+		}
+		b |= uint64(c) << (8 * uint(i))
+	}
+	v3 ^= b
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0 ^= b
+
+	v2 ^= 0xff
+	for i := 0; i < 3; i++ {
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	}
+	return v0 ^ v1 ^ v2 ^ v3
+}
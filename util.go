@@ -0,0 +1,108 @@
+package main
+
+/*
+   通用工具函数
+*/
+
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// stringMatch glob风格匹配，参考 redis stringmatchlen
+// 支持 * ? [abc] [^a-z] 以及 \ 转义，nocase 为 true 时忽略大小写
+func stringMatch(pattern, str string, nocase bool) bool {
+	skipLongerMatches := false
+	return stringMatchImpl(pattern, str, nocase, &skipLongerMatches)
+}
+
+// skipLongerMatches: * 后面的子模式已经无法匹配 str 的任何后缀时置为 true，
+// 外层的 * 再尝试更长的前缀也不可能匹配，直接返回，避免 a*a*a*a*b 这类模式指数回溯
+func stringMatchImpl(pattern, str string, nocase bool, skipLongerMatches *bool) bool {
+	for len(pattern) > 0 && len(str) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for len(str) > 0 {
+				if stringMatchImpl(pattern[1:], str, nocase, skipLongerMatches) {
+					return true
+				}
+				if *skipLongerMatches {
+					return false
+				}
+				str = str[1:]
+			}
+			*skipLongerMatches = true
+			return false
+		case '?':
+			pattern, str = pattern[1:], str[1:]
+		case '[':
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for {
+				if len(pattern) == 0 { // 没有闭合的 ]
+					break
+				}
+				if pattern[0] == '\\' && len(pattern) >= 2 {
+					if pattern[1] == str[0] {
+						match = true
+					}
+					pattern = pattern[2:]
+				} else if pattern[0] == ']' {
+					pattern = pattern[1:]
+					break
+				} else if len(pattern) >= 3 && pattern[1] == '-' {
+					start, end, c := pattern[0], pattern[2], str[0]
+					if start > end {
+						start, end = end, start
+					}
+					if nocase {
+						start, end, c = toLower(start), toLower(end), toLower(c)
+					}
+					if c >= start && c <= end {
+						match = true
+					}
+					pattern = pattern[3:]
+				} else {
+					if pattern[0] == str[0] || (nocase && toLower(pattern[0]) == toLower(str[0])) {
+						match = true
+					}
+					pattern = pattern[1:]
+				}
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			str = str[1:]
+		default:
+			if pattern[0] == '\\' && len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			if pattern[0] != str[0] && !(nocase && toLower(pattern[0]) == toLower(str[0])) {
+				return false
+			}
+			pattern, str = pattern[1:], str[1:]
+		}
+	}
+	// str 已匹配完，剩余的 * 可以匹配空串
+	if len(str) == 0 {
+		for len(pattern) > 0 && pattern[0] == '*' {
+			pattern = pattern[1:]
+		}
+	}
+	return len(pattern) == 0 && len(str) == 0
+}
@@ -1,3 +1,672 @@
 package main
 
-// todo impl zset
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+/*
+   zset 有序集合
+   1. 元素少时使用 listpack 编码，按 (score, member) 有序存放 member,score
+   2. 元素多时使用 skiplist + dict，skiplist 按 (score, member) 排序并记录 span 用于计算排名，
+      dict 存 member -> score，O(1) 查分数
+   所有按 score/lex 的区间查询都先转换为排名区间 [start, end] 再处理
+*/
+
+const (
+	zskiplistMaxLevel = 32   // 跳表最大层数
+	zskiplistP        = 0.25 // 层数晋升概率
+
+	zsetMaxListpackEntries = 128 // listpack 编码最多的元素个数
+	zsetMaxListpackValue   = 64  // listpack 编码 member 的最大长度
+)
+
+type zskiplistLevel struct {
+	forward *zskiplistNode
+	span    int // 到 forward 跨越的节点数
+}
+
+type zskiplistNode struct {
+	member   *Obj
+	score    float64
+	backward *zskiplistNode
+	level    []zskiplistLevel
+}
+
+type zskiplist struct {
+	header *zskiplistNode
+	tail   *zskiplistNode
+	length int
+	level  int
+}
+
+// ZSet skiplist 编码的 zset
+type ZSet struct {
+	dict *Dict // member -> score
+	zsl  *zskiplist
+}
+
+func newZslNode(level int, score float64, member *Obj) *zskiplistNode {
+	return &zskiplistNode{
+		member: member,
+		score:  score,
+		level:  make([]zskiplistLevel, level),
+	}
+}
+
+func newZskiplist() *zskiplist {
+	return &zskiplist{
+		header: newZslNode(zskiplistMaxLevel, 0, nil),
+		level:  1,
+	}
+}
+
+func zslRandomLevel() int {
+	level := 1
+	for level < zskiplistMaxLevel && rand.Float64() < zskiplistP {
+		level++
+	}
+	return level
+}
+
+// (score, member) 排序，score 相同按 member 字典序
+func zslLess(score float64, member *Obj, score2 float64, member2 *Obj) bool {
+	if score != score2 {
+		return score < score2
+	}
+	return member.ToStr() < member2.ToStr()
+}
+
+// Insert 调用方保证 member 不存在
+func (zsl *zskiplist) Insert(score float64, member *Obj) *zskiplistNode {
+	var update [zskiplistMaxLevel]*zskiplistNode
+	var rank [zskiplistMaxLevel]int
+
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		if i < zsl.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for x.level[i].forward != nil && zslLess(x.level[i].forward.score, x.level[i].forward.member, score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	level := zslRandomLevel()
+	if level > zsl.level {
+		for i := zsl.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = zsl.header
+			update[i].level[i].span = zsl.length
+		}
+		zsl.level = level
+	}
+	x = newZslNode(level, score, member)
+	member.incrRefCount()
+	for i := 0; i < level; i++ {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < zsl.level; i++ {
+		update[i].level[i].span++
+	}
+	if update[0] != zsl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		zsl.tail = x
+	}
+	zsl.length++
+	return x
+}
+
+func (zsl *zskiplist) deleteNode(x *zskiplistNode, update []*zskiplistNode) {
+	for i := 0; i < zsl.level; i++ {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		zsl.tail = x.backward
+	}
+	for zsl.level > 1 && zsl.header.level[zsl.level-1].forward == nil {
+		zsl.level--
+	}
+	zsl.length--
+	x.member.decrRefCount()
+}
+
+// Delete 删除 (score, member)，不存在返回 false
+func (zsl *zskiplist) Delete(score float64, member *Obj) bool {
+	update := make([]*zskiplistNode, zskiplistMaxLevel)
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && zslLess(x.level[i].forward.score, x.level[i].forward.member, score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	x = x.level[0].forward
+	if x != nil && x.score == score && Equal(x.member, member) {
+		zsl.deleteNode(x, update)
+		return true
+	}
+	return false
+}
+
+// DeleteRangeByRank 删除排名 [start, end] 的节点 (1-based)，fn 在删除前回调，返回删除个数
+func (zsl *zskiplist) DeleteRangeByRank(start, end int, fn func(x *zskiplistNode)) int {
+	update := make([]*zskiplistNode, zskiplistMaxLevel)
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span < start {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+	traversed++
+	x = x.level[0].forward
+	removed := 0
+	for x != nil && traversed <= end {
+		next := x.level[0].forward
+		if fn != nil {
+			fn(x)
+		}
+		zsl.deleteNode(x, update)
+		removed++
+		traversed++
+		x = next
+	}
+	return removed
+}
+
+// GetRank 返回排名 (1-based)，不存在返回 0
+func (zsl *zskiplist) GetRank(score float64, member *Obj) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil &&
+			(x.level[i].forward.score < score ||
+				(x.level[i].forward.score == score && x.level[i].forward.member.ToStr() <= member.ToStr())) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+		if x.member != nil && Equal(x.member, member) {
+			return rank
+		}
+	}
+	return 0
+}
+
+// GetElementByRank 按排名 (1-based) 获取节点
+func (zsl *zskiplist) GetElementByRank(rank int) *zskiplistNode {
+	traversed := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+		if traversed == rank {
+			return x
+		}
+	}
+	return nil
+}
+
+// CountLess 统计满足 pred 的节点个数，pred 需对有序序列单调 (前缀为 true)
+func (zsl *zskiplist) CountLess(pred func(member *Obj, score float64) bool) int {
+	rank := 0
+	x := zsl.header
+	for i := zsl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && pred(x.level[i].forward.member, x.level[i].forward.score) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+	}
+	return rank
+}
+
+func newScoreObj(score float64) *Obj {
+	return NewObject(GType_Str, score)
+}
+
+// 格式化 score，整数不带小数点和指数
+func formatScore(score float64) string {
+	if math.IsInf(score, 1) {
+		return "inf"
+	}
+	if math.IsInf(score, -1) {
+		return "-inf"
+	}
+	if score == math.Trunc(score) && math.Abs(score) < 1e17 {
+		return strconv.FormatFloat(score, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+func createZsetListpackObject() *Obj {
+	obj := NewObject(GType_ZSet, NewListpack())
+	obj.encoding = GEncoding_Listpack
+	return obj
+}
+
+func createZsetObject() *Obj {
+	obj := NewObject(GType_ZSet, &ZSet{
+		dict: NewDict(StrDictType),
+		zsl:  newZskiplist(),
+	})
+	obj.encoding = GEncoding_Skiplist
+	return obj
+}
+
+// zsetDup 复制zset，保持原编码
+func zsetDup(zobj *Obj) *Obj {
+	if zobj.encoding == GEncoding_Listpack {
+		dup := createZsetListpackObject()
+		lp, dupLp := zobj.ptr.(*Listpack), dup.ptr.(*Listpack)
+		for i := 0; i+1 < len(lp.entries); i += 2 {
+			dupLp.AppendPair(lp.entries[i], lp.entries[i+1])
+		}
+		return dup
+	}
+	dup := createZsetObject()
+	zs, dupZs := zobj.ptr.(*ZSet), dup.ptr.(*ZSet)
+	// 从尾部倒序插入，每次都插在表头
+	for x := zs.zsl.tail; x != nil; x = x.backward {
+		dupZs.zsl.Insert(x.score, x.member)
+		_ = dupZs.dict.Add(x.member, zs.dict.Get(x.member))
+	}
+	return dup
+}
+
+func zsetLength(zobj *Obj) int {
+	if zobj.encoding == GEncoding_Listpack {
+		return zobj.ptr.(*Listpack).Len() / 2
+	}
+	return zobj.ptr.(*ZSet).zsl.length
+}
+
+// zsetRandom 随机返回一个元素，zset 不能为空
+func zsetRandom(zobj *Obj) zsetItem {
+	if zobj.encoding == GEncoding_Listpack {
+		lp := zobj.ptr.(*Listpack)
+		i := rand.Intn(lp.Len()/2) * 2
+		return zsetItem{member: lp.entries[i], score: lpScore(lp, i)}
+	}
+	entry := zobj.ptr.(*ZSet).dict.FairRandomGet()
+	return zsetItem{member: entry.key, score: entry.val.ptr.(float64)}
+}
+
+func lpScore(lp *Listpack, i int) float64 {
+	return lp.entries[i+1].ptr.(float64)
+}
+
+// listpack 中按 (score, member) 有序插入
+func zzlInsert(lp *Listpack, score float64, member *Obj) {
+	i := 0
+	for ; i < len(lp.entries); i += 2 {
+		if zslLess(score, member, lpScore(lp, i), lp.entries[i]) {
+			break
+		}
+	}
+	sobj := newScoreObj(score)
+	lp.InsertPair(i, member, sobj)
+	sobj.decrRefCount()
+}
+
+// zsetConvert listpack 转为 skiplist 编码
+func zsetConvert(zobj *Obj) {
+	if zobj.encoding != GEncoding_Listpack {
+		return
+	}
+	lp := zobj.ptr.(*Listpack)
+	zs := &ZSet{
+		dict: NewDict(StrDictType),
+		zsl:  newZskiplist(),
+	}
+	for i := 0; i < len(lp.entries); i += 2 {
+		zs.zsl.Insert(lpScore(lp, i), lp.entries[i])
+		_ = zs.dict.Add(lp.entries[i], lp.entries[i+1])
+		lp.entries[i].decrRefCount()
+		lp.entries[i+1].decrRefCount()
+	}
+	zobj.ptr = zs
+	zobj.encoding = GEncoding_Skiplist
+}
+
+// member 过长时需要 skiplist 编码
+func zsetTypeMaybeConvert(zobj *Obj, member *Obj) {
+	if zobj.encoding == GEncoding_Listpack && len(member.ToStr()) > zsetMaxListpackValue {
+		zsetConvert(zobj)
+	}
+}
+
+func zsetScore(zobj *Obj, member *Obj) (float64, bool) {
+	if zobj.encoding == GEncoding_Listpack {
+		lp := zobj.ptr.(*Listpack)
+		if i := lp.FindPair(member); i >= 0 {
+			return lpScore(lp, i), true
+		}
+		return 0, false
+	}
+	if sobj := zobj.ptr.(*ZSet).dict.Get(member); sobj != nil {
+		return sobj.ptr.(float64), true
+	}
+	return 0, false
+}
+
+// ZADD 输入标记
+const (
+	zaddIn_Incr = 1 << 0
+	zaddIn_NX   = 1 << 1
+	zaddIn_XX   = 1 << 2
+	zaddIn_GT   = 1 << 3
+	zaddIn_LT   = 1 << 4
+)
+
+// ZADD 输出标记
+const (
+	zaddOut_Nop     = 1 << 0 // 条件不满足，未操作
+	zaddOut_NaN     = 1 << 1 // 结果为 NaN
+	zaddOut_Added   = 1 << 2
+	zaddOut_Updated = 1 << 3
+)
+
+// zsetAdd 添加或更新 member，返回输出标记和最终 score
+func zsetAdd(zobj *Obj, score float64, member *Obj, flags int) (int, float64) {
+	if math.IsNaN(score) {
+		return zaddOut_NaN, 0
+	}
+	cur, exists := zsetScore(zobj, member)
+	if exists {
+		if flags&zaddIn_NX != 0 {
+			return zaddOut_Nop, cur
+		}
+		if flags&zaddIn_Incr != 0 {
+			score += cur
+			if math.IsNaN(score) {
+				return zaddOut_NaN, 0
+			}
+		}
+		if (flags&zaddIn_LT != 0 && score >= cur) || (flags&zaddIn_GT != 0 && score <= cur) {
+			return zaddOut_Nop, cur
+		}
+		if score == cur {
+			return 0, score
+		}
+		if zobj.encoding == GEncoding_Listpack {
+			lp := zobj.ptr.(*Listpack)
+			member.incrRefCount() // 删除后重新插入期间保持引用
+			lp.DeletePair(lp.FindPair(member))
+			zzlInsert(lp, score, member)
+			member.decrRefCount()
+		} else {
+			zs := zobj.ptr.(*ZSet)
+			zs.zsl.Delete(cur, member)
+			zs.zsl.Insert(score, member)
+			sobj := newScoreObj(score)
+			_ = zs.dict.Set(member, sobj)
+			sobj.decrRefCount()
+		}
+		return zaddOut_Updated, score
+	}
+	if flags&zaddIn_XX != 0 {
+		return zaddOut_Nop, 0
+	}
+	zsetTypeMaybeConvert(zobj, member)
+	if zobj.encoding == GEncoding_Listpack {
+		lp := zobj.ptr.(*Listpack)
+		zzlInsert(lp, score, member)
+		if lp.Len()/2 > zsetMaxListpackEntries {
+			zsetConvert(zobj)
+		}
+	} else {
+		zs := zobj.ptr.(*ZSet)
+		zs.zsl.Insert(score, member)
+		sobj := newScoreObj(score)
+		_ = zs.dict.Add(member, sobj)
+		sobj.decrRefCount()
+	}
+	return zaddOut_Added, score
+}
+
+func zsetDel(zobj *Obj, member *Obj) bool {
+	if zobj.encoding == GEncoding_Listpack {
+		lp := zobj.ptr.(*Listpack)
+		if i := lp.FindPair(member); i >= 0 {
+			lp.DeletePair(i)
+			return true
+		}
+		return false
+	}
+	zs := zobj.ptr.(*ZSet)
+	sobj := zs.dict.Get(member)
+	if sobj == nil {
+		return false
+	}
+	zs.zsl.Delete(sobj.ptr.(float64), member)
+	zs.dict.Del(member)
+	return true
+}
+
+// zsetRank 返回 0-based 排名，reverse 时按从大到小
+func zsetRank(zobj *Obj, member *Obj, reverse bool) (int, float64, bool) {
+	length := zsetLength(zobj)
+	rank := -1
+	var score float64
+	if zobj.encoding == GEncoding_Listpack {
+		lp := zobj.ptr.(*Listpack)
+		if i := lp.FindPair(member); i >= 0 {
+			rank, score = i/2, lpScore(lp, i)
+		}
+	} else {
+		zs := zobj.ptr.(*ZSet)
+		if sobj := zs.dict.Get(member); sobj != nil {
+			score = sobj.ptr.(float64)
+			rank = zs.zsl.GetRank(score, member) - 1
+		}
+	}
+	if rank < 0 {
+		return 0, 0, false
+	}
+	if reverse {
+		rank = length - 1 - rank
+	}
+	return rank, score, true
+}
+
+// zsetRangeByRank 遍历排名 [start, end] (0-based，升序排名)，reverse 时从 end 往 start 遍历
+func zsetRangeByRank(zobj *Obj, start, end int, reverse bool, fn func(member *Obj, score float64) bool) {
+	if start > end {
+		return
+	}
+	if zobj.encoding == GEncoding_Listpack {
+		lp := zobj.ptr.(*Listpack)
+		for k := 0; k <= end-start; k++ {
+			i := start + k
+			if reverse {
+				i = end - k
+			}
+			if !fn(lp.entries[2*i], lpScore(lp, 2*i)) {
+				return
+			}
+		}
+		return
+	}
+	zsl := zobj.ptr.(*ZSet).zsl
+	if reverse {
+		for x, n := zsl.GetElementByRank(end+1), end-start+1; x != nil && n > 0; x, n = x.backward, n-1 {
+			if !fn(x.member, x.score) {
+				return
+			}
+		}
+		return
+	}
+	for x, n := zsl.GetElementByRank(start+1), end-start+1; x != nil && n > 0; x, n = x.level[0].forward, n-1 {
+		if !fn(x.member, x.score) {
+			return
+		}
+	}
+}
+
+// zsetCountLess 有序序列中满足 pred 的前缀长度
+func zsetCountLess(zobj *Obj, pred func(member *Obj, score float64) bool) int {
+	if zobj.encoding == GEncoding_Listpack {
+		lp := zobj.ptr.(*Listpack)
+		n := 0
+		for i := 0; i < len(lp.entries) && pred(lp.entries[i], lpScore(lp, i)); i += 2 {
+			n++
+		}
+		return n
+	}
+	return zobj.ptr.(*ZSet).zsl.CountLess(pred)
+}
+
+// zsetDeleteRangeByRank 删除排名 [start, end] (0-based)，返回删除个数
+func zsetDeleteRangeByRank(zobj *Obj, start, end int) int {
+	if start > end {
+		return 0
+	}
+	if zobj.encoding == GEncoding_Listpack {
+		lp := zobj.ptr.(*Listpack)
+		for i := 2 * start; i <= 2*end+1; i++ {
+			lp.entries[i].decrRefCount()
+		}
+		n := len(lp.entries)
+		copy(lp.entries[2*start:], lp.entries[2*end+2:])
+		removed := end - start + 1
+		for i := n - 2*removed; i < n; i++ {
+			lp.entries[i] = nil
+		}
+		lp.entries = lp.entries[:n-2*removed]
+		return removed
+	}
+	zs := zobj.ptr.(*ZSet)
+	return zs.zsl.DeleteRangeByRank(start+1, end+1, func(x *zskiplistNode) {
+		zs.dict.Del(x.member)
+	})
+}
+
+// zrangespec score 区间
+type zrangespec struct {
+	min, max     float64
+	minex, maxex bool // 是否开区间
+}
+
+// 解析 score 区间端点，支持 "(1.5" "-inf" "+inf"
+func parseScoreBound(obj *Obj) (float64, bool, bool) {
+	s := obj.ToStr()
+	ex := false
+	if strings.HasPrefix(s, "(") {
+		ex = true
+		s = s[1:]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(v) {
+		return 0, false, false
+	}
+	return v, ex, true
+}
+
+func parseRange(minObj, maxObj *Obj) (*zrangespec, bool) {
+	lo, loEx, ok1 := parseScoreBound(minObj)
+	hi, hiEx, ok2 := parseScoreBound(maxObj)
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+	return &zrangespec{min: lo, max: hi, minex: loEx, maxex: hiEx}, true
+}
+
+// zsetRankRangeByScore 将 score 区间转为排名区间 [start, end] (0-based)，区间为空时 start > end
+func zsetRankRangeByScore(zobj *Obj, spec *zrangespec) (int, int) {
+	start := zsetCountLess(zobj, func(member *Obj, score float64) bool {
+		return score < spec.min || (spec.minex && score == spec.min)
+	})
+	end := zsetCountLess(zobj, func(member *Obj, score float64) bool {
+		return score < spec.max || (!spec.maxex && score == spec.max)
+	}) - 1
+	return start, end
+}
+
+// zlexrangespec member 字典序区间，要求所有元素 score 相同
+type zlexrangespec struct {
+	min, max       string
+	minex, maxex   bool
+	minInf, maxInf bool // "-" "+"
+	empty          bool // min 为 "+" 或 max 为 "-"
+}
+
+// 解析字典序区间端点，"[a" 闭区间 "(a" 开区间 "-" 负无穷 "+" 正无穷
+func parseLexBound(obj *Obj) (s string, ex bool, negInf bool, posInf bool, ok bool) {
+	str := obj.ToStr()
+	if str == "-" {
+		return "", false, true, false, true
+	}
+	if str == "+" {
+		return "", false, false, true, true
+	}
+	if len(str) == 0 {
+		return "", false, false, false, false
+	}
+	switch str[0] {
+	case '(':
+		return str[1:], true, false, false, true
+	case '[':
+		return str[1:], false, false, false, true
+	}
+	return "", false, false, false, false
+}
+
+func parseLexRange(minObj, maxObj *Obj) (*zlexrangespec, bool) {
+	lo, loEx, loNeg, loPos, ok1 := parseLexBound(minObj)
+	hi, hiEx, hiNeg, hiPos, ok2 := parseLexBound(maxObj)
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+	return &zlexrangespec{
+		min:    lo,
+		max:    hi,
+		minex:  loEx,
+		maxex:  hiEx,
+		minInf: loNeg,
+		maxInf: hiPos,
+		empty:  loPos || hiNeg,
+	}, true
+}
+
+// zsetRankRangeByLex 将字典序区间转为排名区间 [start, end] (0-based)
+func zsetRankRangeByLex(zobj *Obj, spec *zlexrangespec) (int, int) {
+	if spec.empty {
+		return 0, -1
+	}
+	start := 0
+	if !spec.minInf {
+		start = zsetCountLess(zobj, func(member *Obj, score float64) bool {
+			m := member.ToStr()
+			return m < spec.min || (spec.minex && m == spec.min)
+		})
+	}
+	end := zsetLength(zobj) - 1
+	if !spec.maxInf {
+		end = zsetCountLess(zobj, func(member *Obj, score float64) bool {
+			m := member.ToStr()
+			return m < spec.max || (!spec.maxex && m == spec.max)
+		}) - 1
+	}
+	return start, end
+}